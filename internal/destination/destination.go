@@ -0,0 +1,34 @@
+// Package destination opens output targets given as a local path or URL.
+package destination
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// schemeRe matches targets with a URL scheme, like "s3://bucket/key".
+// Windows paths like "C:\out.json" have no "//" and are treated as files.
+var schemeRe = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*)://`)
+
+// Open opens the output target for writing. Supported targets are local
+// paths, file:// URLs and s3://bucket/key. The output is only complete once
+// Close returned without error; remote targets are uploaded on Close.
+func Open(ctx context.Context, target string) (io.WriteCloser, error) {
+	m := schemeRe.FindStringSubmatch(target)
+	if m == nil {
+		return os.Create(target)
+	}
+
+	switch strings.ToLower(m[1]) {
+	case "file":
+		return os.Create(strings.TrimPrefix(target, m[0]))
+	case "s3":
+		return newS3Writer(ctx, strings.TrimPrefix(target, m[0]))
+	default:
+		return nil, fmt.Errorf("unsupported output scheme %q (supported: file, s3)", m[1])
+	}
+}
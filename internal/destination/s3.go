@@ -0,0 +1,166 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Writer buffers the output in memory and uploads it to S3 on Close.
+// Credentials and region are read from the standard AWS environment variables;
+// AWS_ENDPOINT_URL selects an S3-compatible server like MinIO.
+type s3Writer struct {
+	ctx    context.Context
+	bucket string
+	key    string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newS3Writer(ctx context.Context, location string) (*s3Writer, error) {
+	bucket, key, ok := strings.Cut(location, "/")
+	if !ok || bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 target %q (expected s3://bucket/key)", "s3://"+location)
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+		return nil, fmt.Errorf("S3 output needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return &s3Writer{ctx: ctx, bucket: bucket, key: key}, nil
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close uploads the buffered output. Further calls do nothing.
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// Custom endpoints use path-style URLs, AWS virtual-hosted-style ones
+	var u string
+	if endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); endpoint != "" {
+		u = strings.TrimSuffix(endpoint, "/") + "/" + w.bucket + "/" + s3EscapePath(w.key)
+	} else {
+		u = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", w.bucket, region, s3EscapePath(w.key))
+	}
+
+	body := w.buf.Bytes()
+	req, err := http.NewRequestWithContext(w.ctx, "PUT", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(sum[:]))
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("x-amz-security-token", token)
+	}
+	signV4(req, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), region, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload to s3://%s/%s: %w", w.bucket, w.key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to upload to s3://%s/%s: status %d: %s", w.bucket, w.key, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// s3EscapePath escapes an object key for the URL path like S3 expects it:
+// everything except unreserved characters and slashes is percent-encoded.
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signV4 signs an S3 request with AWS Signature Version 4, covering the host
+// and all headers already set. The x-amz-content-sha256 header must be set.
+func signV4(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,84 @@
+package fshelper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ListFiles calls fn with the path of every file in the input, without
+// opening it as fs.FS. ZIP files only need their directory read; tarballs
+// have none, so the whole stream is decompressed, without writing it to
+// disk like OpenTarGz. Files in several parts of a merged input are
+// listed once per part.
+func (in Input) ListFiles(fn func(name string)) error {
+	if len(in.Parts) > 0 {
+		for _, part := range in.Parts {
+			if err := part.ListFiles(fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	lower := strings.ToLower(in.Path)
+	switch {
+	case in.Format == FormatZip || (in.Format == FormatAuto && strings.HasSuffix(lower, ".zip")):
+		r, err := zip.OpenReader(in.Path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if !strings.HasSuffix(f.Name, "/") {
+				fn(f.Name)
+			}
+		}
+		return nil
+
+	case in.Format == FormatTarGz || (in.Format == FormatAuto && isTarGz(in.Path)):
+		f, err := os.Open(in.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r := tar.NewReader(gz)
+		for {
+			hdr, err := r.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				fn(strings.TrimPrefix(hdr.Name, "./"))
+			}
+		}
+
+	default:
+		return filepath.WalkDir(in.Path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				rel, err := filepath.Rel(in.Path, p)
+				if err != nil {
+					return err
+				}
+				fn(filepath.ToSlash(rel))
+			}
+			return nil
+		})
+	}
+}
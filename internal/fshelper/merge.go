@@ -0,0 +1,146 @@
+package fshelper
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// splitRe matches the archives of a split takeout export, like
+// "takeout-20240101T120000Z-001.zip": the export name and the part number.
+var splitRe = regexp.MustCompile(`(?i)^(.+)-(\d+)\.(zip|tgz|tar\.gz)$`)
+
+// MergeSplits combines the archive inputs (ZIP files or tarballs) that are
+// parts of the same split export (same directory and name up to the part
+// number) into one input, opened as a MergedFS. The merged input is named after the export, without
+// the part number, and takes the place of its first part. Other inputs are
+// kept as they are.
+func MergeSplits(inputs []Input) []Input {
+	groups := make(map[string][]Input)
+	for _, in := range inputs {
+		if prefix, ok := splitPrefix(in); ok {
+			groups[prefix] = append(groups[prefix], in)
+		}
+	}
+
+	var result []Input
+	done := make(map[string]bool)
+	for _, in := range inputs {
+		prefix, ok := splitPrefix(in)
+		if !ok || len(groups[prefix]) < 2 {
+			result = append(result, in)
+			continue
+		}
+		if done[prefix] {
+			continue
+		}
+		done[prefix] = true
+		parts := groups[prefix]
+		slices.SortStableFunc(parts, func(a, b Input) int {
+			return partNumber(a.Path) - partNumber(b.Path)
+		})
+		result = append(result, Input{Path: prefix, Format: in.Format, Parts: parts})
+	}
+	return result
+}
+
+// splitPrefix returns the path of an input without its part number, if it
+// is an archive part of a split export.
+func splitPrefix(in Input) (string, bool) {
+	if in.Format == FormatDir || len(in.Parts) > 0 {
+		return "", false
+	}
+	m := splitRe.FindStringSubmatch(filepath.Base(in.Path))
+	if m == nil {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(in.Path), m[1]), true
+}
+
+// partNumber returns the part number of a split archive path.
+func partNumber(p string) int {
+	m := splitRe.FindStringSubmatch(filepath.Base(p))
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[2])
+	return n
+}
+
+// MergedFS overlays the archives of a split export, so a media file and its
+// JSON sidecar are found together even if they ended up in different parts.
+// A file in several parts is read from the first one.
+type MergedFS struct {
+	parts []fs.FS
+	name  string
+}
+
+// openMerged opens all parts of a merged input.
+func openMerged(in Input) (*MergedFS, error) {
+	m := &MergedFS{name: filepath.Base(in.Path)}
+	for _, part := range in.Parts {
+		fsys, err := part.Open()
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.parts = append(m.parts, fsys)
+	}
+	return m, nil
+}
+
+// Close closes all parts.
+func (m *MergedFS) Close() error {
+	return CloseFSs(m.parts)
+}
+
+// Name returns the name of the export, without the part number.
+func (m *MergedFS) Name() string {
+	return m.name
+}
+
+// Open implements fs.FS.
+func (m *MergedFS) Open(name string) (fs.File, error) {
+	for _, part := range m.parts {
+		f, err := part.Open(name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS. A directory lists the entries of all
+// parts, as the files of a folder are often split across them.
+func (m *MergedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	found := false
+	for _, part := range m.parts {
+		partEntries, err := fs.ReadDir(part, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, e := range partEntries {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
@@ -1,13 +1,17 @@
-// Package fshelper provides filesystem utilities for reading ZIP files.
+// Package fshelper provides filesystem utilities for reading ZIP files and tarballs.
 // This is a simplified version inspired by github.com/simulot/immich-go/internal/fshelper
 package fshelper
 
 import (
 	"archive/zip"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
 )
 
 // ZipFS wraps a zip.Reader to implement fs.FS.
@@ -36,6 +40,8 @@ func OpenZip(path string) (*ZipFS, error) {
 		return nil, err
 	}
 
+	decodeNames(r)
+
 	name := filepath.Base(path)
 	name = strings.TrimSuffix(name, filepath.Ext(name))
 
@@ -46,6 +52,22 @@ func OpenZip(path string) (*ZipFS, error) {
 	}, nil
 }
 
+// decodeNames converts the entry names that aren't valid UTF-8 from CP437,
+// the encoding of ZIP files without the UTF-8 flag, so the paths in the
+// output are valid UTF-8. It must run before the first Open, which indexes
+// the names.
+func decodeNames(r *zip.Reader) {
+	decoder := charmap.CodePage437.NewDecoder()
+	for _, f := range r.File {
+		if utf8.ValidString(f.Name) {
+			continue
+		}
+		if name, err := decoder.String(f.Name); err == nil {
+			f.Name = name
+		}
+	}
+}
+
 // Close closes the underlying file.
 func (z *ZipFS) Close() error {
 	return z.file.Close()
@@ -61,11 +83,45 @@ func (z *ZipFS) Open(name string) (fs.File, error) {
 	return z.Reader.Open(name)
 }
 
-// ParsePaths parses a list of paths and returns fs.FS instances.
-// Supports ZIP files and glob patterns.
-func ParsePaths(paths []string) ([]fs.FS, error) {
-	var result []fs.FS
+// Input formats for ResolvePaths.
+const (
+	FormatAuto  = ""    // detect by file extension
+	FormatZip   = "zip" // open every path as a ZIP file
+	FormatTarGz = "tgz" // open every path as a gzip-compressed tar file
+	FormatDir   = "dir" // open every path as a directory
+)
+
+// Input is a takeout path that is opened on demand, so that not all archives
+// need to be open at the same time.
+type Input struct {
+	Path   string
+	Format string
+	// Parts are the archives of a split export merged by MergeSplits;
+	// Path is then the export name without the part number.
+	Parts []Input
+}
+
+// Open opens the input as fs.FS. Close it with CloseFSs when done.
+func (in Input) Open() (fs.FS, error) {
+	if len(in.Parts) > 0 {
+		return openMerged(in)
+	}
+	return openPath(in.Path, in.Format)
+}
+
+// ResolvePaths expands the glob patterns in paths and checks that all
+// matches exist, without opening them. With FormatAuto, paths ending in .zip
+// are opened as ZIP files, paths ending in .tgz or .tar.gz as tarballs and
+// everything else as a directory; any other
+// format forces how all paths are opened, regardless of their name.
+func ResolvePaths(paths []string, format string) ([]Input, error) {
+	switch format {
+	case FormatAuto, FormatZip, FormatTarGz, FormatDir:
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
+	}
 
+	var result []Input
 	for _, p := range paths {
 		// Expand glob patterns
 		matches, err := filepath.Glob(p)
@@ -78,23 +134,58 @@ func ParsePaths(paths []string) ([]fs.FS, error) {
 		}
 
 		for _, match := range matches {
-			lower := strings.ToLower(match)
-			if strings.HasSuffix(lower, ".zip") {
-				zfs, err := OpenZip(match)
-				if err != nil {
-					return nil, err
-				}
-				result = append(result, zfs)
-			} else {
-				// For directories, use os.DirFS
-				result = append(result, os.DirFS(match))
+			stat, err := os.Stat(match)
+			if err != nil {
+				return nil, err
 			}
+			if format == FormatDir && !stat.IsDir() {
+				return nil, fmt.Errorf("%s: not a directory", match)
+			}
+			result = append(result, Input{Path: match, Format: format})
 		}
 	}
 
 	return result, nil
 }
 
+// openPath opens a single path in the given format.
+func openPath(p, format string) (fs.FS, error) {
+	switch format {
+	case FormatAuto:
+		if strings.HasSuffix(strings.ToLower(p), ".zip") {
+			return OpenZip(p)
+		}
+		if isTarGz(p) {
+			return OpenTarGz(p)
+		}
+		// For directories, use os.DirFS
+		return os.DirFS(p), nil
+	case FormatZip:
+		zfs, err := OpenZip(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot open as zip: %w", p, err)
+		}
+		return zfs, nil
+	case FormatTarGz:
+		tfs, err := OpenTarGz(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot open as tar.gz: %w", p, err)
+		}
+		return tfs, nil
+	case FormatDir:
+		stat, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			return nil, fmt.Errorf("%s: not a directory", p)
+		}
+		return os.DirFS(p), nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
+	}
+}
+
 // CloseFSs closes all fs.FS instances that have a Close method.
 func CloseFSs(fsyss []fs.FS) error {
 	var lastErr error
@@ -0,0 +1,70 @@
+package googlephotos
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ArchiveBrowserFile is the name of the overview page Google adds to takeouts.
+const ArchiveBrowserFile = "archive_browser.html"
+
+var (
+	// browserTagRe matches HTML tags, which are replaced by line breaks.
+	browserTagRe = regexp.MustCompile(`<[^>]*>`)
+	// browserCountRe matches an item count like "340 items" or "1,024 photos".
+	browserCountRe = regexp.MustCompile(`^(\d[\d.,]*)\s+(?:items?|files?|photos?|videos?|photos and videos)$`)
+	// browserInlineRe matches a folder with its count on one line,
+	// like "Vacation (340 items)" or "Vacation · 340 items".
+	browserInlineRe = regexp.MustCompile(`^(.+?)\s*(?:\(|·|-|–)\s*(\d[\d.,]*\s+\S.*?)\)?$`)
+)
+
+// ParseArchiveBrowser extracts the folder name -> item count listing from
+// archive_browser.html. The page format is not documented and changes from
+// time to time, so only folders followed by an explicit item count are
+// recognized. An error is returned if no counts are found at all.
+func ParseArchiveBrowser(data []byte) (map[string]int, error) {
+	text := browserTagRe.ReplaceAllString(string(data), "\n")
+
+	counts := make(map[string]int)
+	var prev string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(html.UnescapeString(line)), " ")
+		if line == "" {
+			continue
+		}
+		if n, ok := parseBrowserCount(line); ok && prev != "" {
+			counts[prev] = n
+			prev = ""
+			continue
+		}
+		if m := browserInlineRe.FindStringSubmatch(line); m != nil {
+			if n, ok := parseBrowserCount(m[2]); ok {
+				counts[m[1]] = n
+				prev = ""
+				continue
+			}
+		}
+		prev = line
+	}
+
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("no folder counts found")
+	}
+	return counts, nil
+}
+
+// parseBrowserCount parses an item count like "1,024 items".
+func parseBrowserCount(s string) (int, bool) {
+	m := browserCountRe.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.NewReplacer(",", "", ".", "").Replace(m[1]))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
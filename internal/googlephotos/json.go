@@ -27,6 +27,12 @@ type GoogleMetaData struct {
 	GooglePhotosOrigin struct {
 		FromPartnerSharing bool `json:"fromPartnerSharing,omitempty"`
 	} `json:"googlePhotosOrigin"`
+	// Album metadata only: shared albums have an access level and may have comments
+	Access              string            `json:"access,omitempty"`
+	SharedAlbumComments []json.RawMessage `json:"sharedAlbumComments,omitempty"`
+	// Some shared albums list the metadata of their assets in the album JSON
+	// instead of one sidecar per asset
+	MediaItems []*GoogleMetaData `json:"mediaItems,omitempty"`
 }
 
 // Person represents a tagged person in the photo.
@@ -39,13 +45,18 @@ func (gmd *GoogleMetaData) UnmarshalJSON(data []byte) error {
 	// Test the presence of the key albumData (album metadata format)
 	type md GoogleMetaData
 	type album struct {
-		AlbumData *md `json:"albumData"`
+		AlbumData  *md               `json:"albumData"`
+		MediaItems []*GoogleMetaData `json:"mediaItems"`
 	}
 
 	var t album
 	err := json.Unmarshal(data, &t)
 	if err == nil && t.AlbumData != nil {
 		*gmd = GoogleMetaData(*(t.AlbumData))
+		// The asset list may be next to albumData instead of inside it
+		if len(gmd.MediaItems) == 0 {
+			gmd.MediaItems = t.MediaItems
+		}
 		return nil
 	}
 
@@ -75,6 +86,32 @@ func (gmd *GoogleMetaData) IsAlbum() bool {
 	return gmd.Title != ""
 }
 
+// IsUntitledAlbum returns true if this metadata represents an album without
+// title. Unlike asset metadata, album metadata has a creation date.
+func (gmd *GoogleMetaData) IsUntitledAlbum() bool {
+	return gmd != nil && !gmd.IsAsset() && gmd.Title == "" && gmd.Date != nil && gmd.Date.Timestamp != ""
+}
+
+// IsSharedAlbum returns true if this metadata represents a shared album.
+func (gmd *GoogleMetaData) IsSharedAlbum() bool {
+	return gmd.IsAlbum() && (gmd.Access != "" || len(gmd.SharedAlbumComments) > 0)
+}
+
+// AlbumAssets returns the assets listed in a multi-asset (shared) album JSON
+// that have a Google Photos URL.
+func (gmd *GoogleMetaData) AlbumAssets() []*GoogleMetaData {
+	if !gmd.IsAlbum() {
+		return nil
+	}
+	var assets []*GoogleMetaData
+	for _, item := range gmd.MediaItems {
+		if item.IsAsset() && item.HasURL() {
+			assets = append(assets, item)
+		}
+	}
+	return assets
+}
+
 // HasURL returns true if this metadata contains a Google Photos URL.
 func (gmd *GoogleMetaData) HasURL() bool {
 	return gmd != nil && gmd.URL != ""
@@ -93,6 +130,8 @@ type GoogTimeObject struct {
 }
 
 // Time returns the time.Time representation of the epoch timestamp.
+// Google stores an absolute UTC epoch, so the result is always in UTC;
+// callers that need a wall-clock reading must convert it to the wanted zone.
 func (gt *GoogTimeObject) Time() time.Time {
 	if gt == nil {
 		return time.Time{}
@@ -101,7 +140,7 @@ func (gt *GoogTimeObject) Time() time.Time {
 	if ts == 0 {
 		return time.Time{}
 	}
-	return time.Unix(ts, 0).In(time.Local)
+	return time.Unix(ts, 0).UTC()
 }
 
 // ParseMetadata parses JSON data into GoogleMetaData.
@@ -0,0 +1,100 @@
+package googlephotos
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGoogTimeObjectTime(t *testing.T) {
+	tests := []struct {
+		timestamp string
+		want      time.Time
+	}{
+		// Around the spring-forward and fall-back gaps of Europe/Berlin
+		{"1711848600", time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC)},
+		{"1729989000", time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC)},
+		{"1729992600", time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC)},
+		{"", time.Time{}},
+		{"invalid", time.Time{}},
+	}
+	for _, tt := range tests {
+		got := (&GoogTimeObject{Timestamp: tt.timestamp}).Time()
+		if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("Time() of %q = %v, want %v in UTC", tt.timestamp, got, tt.want)
+		}
+	}
+	if got := (*GoogTimeObject)(nil).Time(); !got.IsZero() {
+		t.Errorf("Time() of nil = %v, want zero", got)
+	}
+}
+
+func TestSharedAlbumAssets(t *testing.T) {
+	data := []byte(`{
+		"title": "Holiday 2023",
+		"access": "protected",
+		"date": {"timestamp": "1690000000"},
+		"mediaItems": [
+			{"title": "IMG_0001.jpg", "url": "https://photos.google.com/photo/A1", "photoTakenTime": {"timestamp": "1690000100"}},
+			{"title": "IMG_0002.HEIC", "url": "https://photos.google.com/photo/A2", "photoTakenTime": {"timestamp": "1690000200"}},
+			{"title": "IMG_0003.jpg", "photoTakenTime": {"timestamp": "1690000300"}},
+			{"title": "no-time.jpg", "url": "https://photos.google.com/photo/A4"}
+		]
+	}`)
+	md, err := ParseMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !md.IsAlbum() || !md.IsSharedAlbum() || md.IsAsset() {
+		t.Fatalf("album: IsAlbum %v, IsSharedAlbum %v, IsAsset %v", md.IsAlbum(), md.IsSharedAlbum(), md.IsAsset())
+	}
+	assets := md.AlbumAssets()
+	var urls []string
+	for _, a := range assets {
+		urls = append(urls, a.URL)
+	}
+	if want := []string{"https://photos.google.com/photo/A1", "https://photos.google.com/photo/A2"}; !slices.Equal(urls, want) {
+		t.Errorf("AlbumAssets URLs = %v, want %v", urls, want)
+	}
+	if len(assets) > 1 && (assets[1].Title != "IMG_0002.HEIC" || assets[1].PhotoTakenTime.Time().Unix() != 1690000200) {
+		t.Errorf("second asset = %q taken %v", assets[1].Title, assets[1].PhotoTakenTime.Time())
+	}
+}
+
+func TestSharedAlbumAssetsNextToAlbumData(t *testing.T) {
+	data := []byte(`{
+		"albumData": {"title": "Family", "access": "protected"},
+		"mediaItems": [
+			{"title": "IMG_0001.jpg", "url": "https://photos.google.com/photo/B1", "photoTakenTime": {"timestamp": "1690000100"}}
+		]
+	}`)
+	md, err := ParseMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Title != "Family" || !md.IsSharedAlbum() {
+		t.Fatalf("album %q: IsSharedAlbum %v", md.Title, md.IsSharedAlbum())
+	}
+	if assets := md.AlbumAssets(); len(assets) != 1 || assets[0].URL != "https://photos.google.com/photo/B1" {
+		t.Errorf("AlbumAssets = %v, want the asset B1", assets)
+	}
+}
+
+func TestAssetSidecarIsNoAlbum(t *testing.T) {
+	data := []byte(`{
+		"title": "IMG_0001.jpg",
+		"url": "https://photos.google.com/photo/C1",
+		"photoTakenTime": {"timestamp": "1690000100"},
+		"people": [{"name": "Alice"}]
+	}`)
+	md, err := ParseMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !md.IsAsset() || !md.HasURL() {
+		t.Errorf("sidecar: IsAsset %v, HasURL %v", md.IsAsset(), md.HasURL())
+	}
+	if md.IsAlbum() || md.IsSharedAlbum() || md.AlbumAssets() != nil {
+		t.Errorf("sidecar taken for an album: IsAlbum %v, IsSharedAlbum %v, AlbumAssets %v", md.IsAlbum(), md.IsSharedAlbum(), md.AlbumAssets())
+	}
+}
@@ -0,0 +1,321 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// immichAlbum is the subset of the Immich album response used by the mapper.
+type immichAlbum struct {
+	ID        string `json:"id"`
+	AlbumName string `json:"albumName"`
+}
+
+// assetAlbums returns the Immich albums an asset belongs to.
+// Results are cached per asset, so repeated lookups don't hit the server.
+func (m *Mapper) assetAlbums(ctx context.Context, assetID string) ([]immichAlbum, error) {
+	if albums, ok := m.albumCache[assetID]; ok {
+		return albums, nil
+	}
+
+	var albums []immichAlbum
+	if err := m.apiRequest(ctx, "GET", "/api/albums?assetId="+url.QueryEscape(assetID), nil, &albums); err != nil {
+		return nil, err
+	}
+
+	m.albumCache[assetID] = albums
+	return albums, nil
+}
+
+// assetURL returns the Immich URL for an asset.
+// With --link-in-album, assets that belong to exactly one album are linked
+// within that album's view; otherwise the plain photos URL is used.
+func (m *Mapper) assetURL(ctx context.Context, assetID string) string {
+	if m.linkInAlbum {
+		albums, err := m.assetAlbums(ctx, assetID)
+		if err != nil {
+			m.logger("Warning: failed to query albums for asset %s: %v", assetID, err)
+		} else if len(albums) == 1 {
+			return m.webURL("albums", albums[0].ID, "photos", assetID)
+		}
+	}
+	return m.PhotoURL(assetID)
+}
+
+// albumURLs returns the album-scoped Immich URLs of an asset, one per album
+// it belongs to. The albums come from the same cache as assetURL.
+func (m *Mapper) albumURLs(ctx context.Context, assetID string) []string {
+	albums, err := m.assetAlbums(ctx, assetID)
+	if err != nil {
+		m.logger("Warning: failed to query albums for asset %s: %v", assetID, err)
+		return nil
+	}
+	var urls []string
+	for _, album := range albums {
+		urls = append(urls, m.webURL("albums", album.ID, "photos", assetID))
+	}
+	return urls
+}
+
+// listAlbums returns all Immich albums of the user.
+func (m *Mapper) listAlbums(ctx context.Context) ([]immichAlbum, error) {
+	var albums []immichAlbum
+	if err := m.apiRequest(ctx, "GET", "/api/albums", nil, &albums); err != nil {
+		return nil, fmt.Errorf("failed to list Immich albums: %w", err)
+	}
+	return albums, nil
+}
+
+// AlbumMapping maps a Google album to the Immich album of the same title.
+type AlbumMapping struct {
+	Album         string `json:"album"`
+	ImmichAlbumID string `json:"immich_album_id"`
+	AlbumURL      string `json:"album_url"`
+}
+
+// mapGoogleAlbums resolves the Immich album of each Google album in the
+// takeout by title, listing the Immich albums once. If several Immich albums
+// have the title, the first one listed is used.
+func (m *Mapper) mapGoogleAlbums(ctx context.Context, result *Result) error {
+	existing, err := m.listAlbums(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]string)
+	for _, a := range existing {
+		if _, ok := byName[a.AlbumName]; !ok {
+			byName[a.AlbumName] = a.ID
+		}
+	}
+
+	titles := make(map[string]bool)
+	for _, title := range m.albums.titles {
+		titles[title] = true
+	}
+	names := make([]string, 0, len(titles))
+	for title := range titles {
+		names = append(names, title)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			result.NotFoundAlbums = append(result.NotFoundAlbums, name)
+			continue
+		}
+		result.Albums = append(result.Albums, AlbumMapping{
+			Album:         name,
+			ImmichAlbumID: id,
+			AlbumURL:      m.webURL("albums", id),
+		})
+	}
+	return nil
+}
+
+// albumPlan collects the matched Immich assets of each Google album folder.
+// Album folders are identified by their album metadata JSON; folders without
+// one (e.g. "Photos from 2023") are not albums.
+type albumPlan struct {
+	titles map[string]string   // album dir -> album title
+	assets map[string][]string // album dir -> matched asset IDs
+	shared map[string]bool     // album dirs of shared albums
+	total  map[string]int      // album dir -> assets with a Google URL
+}
+
+func newAlbumPlan() *albumPlan {
+	return &albumPlan{
+		titles: make(map[string]string),
+		assets: make(map[string][]string),
+		shared: make(map[string]bool),
+		total:  make(map[string]int),
+	}
+}
+
+// addAlbum records dir as an album folder with the given title.
+func (p *albumPlan) addAlbum(dir, title string) {
+	p.titles[dir] = title
+}
+
+// markShared records dir as the folder of a shared album.
+func (p *albumPlan) markShared(dir string) {
+	p.shared[dir] = true
+}
+
+// isShared returns true if dir is the folder of a shared album.
+func (p *albumPlan) isShared(dir string) bool {
+	return p.shared[dir]
+}
+
+// Mapping.Source of assets outside of shared albums (see albumPlan.source).
+const (
+	SourceLibrary     = "library" // not in an album folder, e.g. "Photos from 2023"
+	SourceAlbumPrefix = "album:"  // followed by the album title
+)
+
+// source returns the Mapping.Source of an asset in dir: SourceSharedAlbum,
+// SourceAlbumPrefix and the album title, or SourceLibrary.
+func (p *albumPlan) source(dir string) string {
+	if p.shared[dir] {
+		return SourceSharedAlbum
+	}
+	if title, ok := p.titles[dir]; ok {
+		return SourceAlbumPrefix + title
+	}
+	return SourceLibrary
+}
+
+// countAsset records an asset with a Google URL found in dir, matched or not.
+func (p *albumPlan) countAsset(dir string) {
+	p.total[dir]++
+}
+
+// addAsset records a matched asset found in dir.
+func (p *albumPlan) addAsset(dir, assetID string) {
+	p.assets[dir] = append(p.assets[dir], assetID)
+}
+
+// build returns the album name -> unique asset IDs mapping.
+// Folders with the same album title are merged.
+func (p *albumPlan) build() map[string][]string {
+	plan := make(map[string][]string)
+	seen := make(map[string]map[string]bool)
+	for dir, title := range p.titles {
+		if seen[title] == nil {
+			seen[title] = make(map[string]bool)
+			plan[title] = make([]string, 0)
+		}
+		for _, id := range p.assets[dir] {
+			if !seen[title][id] {
+				seen[title][id] = true
+				plan[title] = append(plan[title], id)
+			}
+		}
+	}
+	return plan
+}
+
+// AlbumCleanup is the migration state of a Google album.
+type AlbumCleanup struct {
+	Album   string `json:"album"`
+	Assets  int    `json:"assets"`  // assets with a Google URL
+	Matched int    `json:"matched"` // assets matched in Immich
+}
+
+// CleanupReport lists the Google albums whose assets were all matched in
+// Immich, and those that still have unmatched assets.
+type CleanupReport struct {
+	SafeToDelete []AlbumCleanup `json:"safe_to_delete"`
+	Keep         []AlbumCleanup `json:"keep"`
+}
+
+// cleanup builds the cleanup report, sorted by album name. Folders with the
+// same album title are merged. Albums without assets are kept, as there is
+// nothing to verify.
+func (p *albumPlan) cleanup() *CleanupReport {
+	byTitle := make(map[string]*AlbumCleanup)
+	var titles []string
+	for dir, title := range p.titles {
+		a := byTitle[title]
+		if a == nil {
+			a = &AlbumCleanup{Album: title}
+			byTitle[title] = a
+			titles = append(titles, title)
+		}
+		a.Assets += p.total[dir]
+		a.Matched += len(p.assets[dir])
+	}
+	sort.Strings(titles)
+
+	report := &CleanupReport{
+		SafeToDelete: make([]AlbumCleanup, 0),
+		Keep:         make([]AlbumCleanup, 0),
+	}
+	for _, title := range titles {
+		a := byTitle[title]
+		if a.Assets > 0 && a.Matched == a.Assets {
+			report.SafeToDelete = append(report.SafeToDelete, *a)
+		} else {
+			report.Keep = append(report.Keep, *a)
+		}
+	}
+	return report
+}
+
+// Album conflict policies for CreateAlbums, applied when an Immich album
+// with the same name already exists.
+const (
+	AlbumConflictReuse = "reuse" // add the assets to the existing album
+	AlbumConflictSkip  = "skip"  // leave the existing album untouched
+)
+
+// AlbumReport summarizes the albums changed by CreateAlbums.
+type AlbumReport struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Skipped []string `json:"skipped"`
+}
+
+// CreateAlbums recreates the Google albums of the album plan in Immich and
+// adds their matched assets. The API key needs permission to create albums
+// and add assets to them.
+func (m *Mapper) CreateAlbums(ctx context.Context, plan map[string][]string, conflict string) (*AlbumReport, error) {
+	if m.dryRun {
+		return nil, fmt.Errorf("cannot create albums in dry-run mode")
+	}
+
+	existing, err := m.listAlbums(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]string)
+	for _, a := range existing {
+		byName[a.AlbumName] = a.ID
+	}
+
+	names := make([]string, 0, len(plan))
+	for name := range plan {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := &AlbumReport{
+		Created: make([]string, 0),
+		Updated: make([]string, 0),
+		Skipped: make([]string, 0),
+	}
+	for _, name := range names {
+		assetIDs := plan[name]
+		if len(assetIDs) == 0 {
+			continue
+		}
+
+		if id, ok := byName[name]; ok {
+			if conflict == AlbumConflictSkip {
+				m.logger("Album %q already exists in Immich, skipping", name)
+				report.Skipped = append(report.Skipped, name)
+				continue
+			}
+			body := map[string]interface{}{"ids": assetIDs}
+			var results []interface{}
+			if err := m.apiRequest(ctx, "PUT", "/api/albums/"+url.PathEscape(id)+"/assets", body, &results); err != nil {
+				return report, fmt.Errorf("failed to add assets to album %q: %w", name, err)
+			}
+			m.logger("Added %d assets to existing album %q", len(assetIDs), name)
+			report.Updated = append(report.Updated, name)
+			continue
+		}
+
+		body := map[string]interface{}{"albumName": name, "assetIds": assetIDs}
+		var created immichAlbum
+		if err := m.apiRequest(ctx, "POST", "/api/albums", body, &created); err != nil {
+			return report, fmt.Errorf("failed to create album %q: %w", name, err)
+		}
+		m.logger("Created album %q with %d assets", name, len(assetIDs))
+		report.Created = append(report.Created, name)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,68 @@
+package mapper
+
+import (
+	"io/fs"
+	"sort"
+
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/googlephotos"
+)
+
+// BrowserMismatch is a folder whose item count in archive_browser.html differs
+// from the number of media files found in the takeout.
+type BrowserMismatch struct {
+	Folder    string `json:"folder"`
+	Listed    int    `json:"listed"`
+	Processed int    `json:"processed"`
+}
+
+// readArchiveBrowser adds the folder counts of an archive_browser.html to
+// the expected counts. Parse errors are only logged, as the page format is
+// not documented.
+func (m *Mapper) readArchiveBrowser(fsys fs.FS, fpath string) {
+	data, err := fs.ReadFile(fsys, fpath)
+	if err != nil {
+		m.logger("Warning: failed to read %s: %v", fpath, err)
+		return
+	}
+	counts, err := googlephotos.ParseArchiveBrowser(data)
+	if err != nil {
+		m.logger("Warning: failed to parse %s, skipping the cross-check: %v", fpath, err)
+		return
+	}
+	if m.browserListed == nil {
+		m.browserListed = make(map[string]int)
+	}
+	for folder, n := range counts {
+		m.browserListed[folder] = n
+	}
+}
+
+// compareArchiveBrowser compares the listed folder counts with the media files
+// found in all takeout paths. Folders of other Google products that are listed
+// in the page but have no files in the takeout are ignored.
+func (m *Mapper) compareArchiveBrowser(result *Result) {
+	if m.browserListed == nil {
+		m.logger("Warning: no usable %s found, skipping the cross-check", googlephotos.ArchiveBrowserFile)
+		return
+	}
+
+	folders := make([]string, 0, len(m.browserProcessed))
+	for folder := range m.browserProcessed {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	for _, folder := range folders {
+		listed, ok := m.browserListed[folder]
+		processed := m.browserProcessed[folder]
+		if !ok || listed == processed {
+			continue
+		}
+		m.logger("Warning: folder %q lists %d items in %s, processed %d", folder, listed, googlephotos.ArchiveBrowserFile, processed)
+		result.BrowserMismatches = append(result.BrowserMismatches, BrowserMismatch{
+			Folder:    folder,
+			Listed:    listed,
+			Processed: processed,
+		})
+	}
+}
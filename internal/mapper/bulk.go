@@ -0,0 +1,120 @@
+package mapper
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/simulot/immich-go/immich"
+)
+
+// bulkCheckBatchSize is the number of checksums sent per bulk existence check.
+const bulkCheckBatchSize = 1000
+
+// archivePageSize is the page size used to list the archived assets, the
+// largest that Immich accepts.
+const archivePageSize = 1000
+
+// bulkCheckRequest matches the body of /api/assets/bulk-upload-check.
+type bulkCheckRequest struct {
+	Assets []bulkCheckItem `json:"assets"`
+}
+
+type bulkCheckItem struct {
+	ID       string `json:"id"`
+	Checksum string `json:"checksum"`
+}
+
+// bulkCheckResponse matches the response of /api/assets/bulk-upload-check.
+type bulkCheckResponse struct {
+	Results []struct {
+		ID        string `json:"id"`
+		Action    string `json:"action"` // "accept" or "reject"
+		Reason    string `json:"reason"` // "duplicate" if the checksum already exists
+		AssetID   string `json:"assetId"`
+		IsTrashed bool   `json:"isTrashed"`
+	} `json:"results"`
+}
+
+// bulkCheckExisting checks which of the given hashes already exist in Immich,
+// using the endpoint clients call before uploading. It returns the Immich asset
+// ID for every existing hash. Trashed assets are not considered existing, just
+// like in the metadata search, unless --search-trash is set; they are then
+// recorded in trashedIDs. The bulk check doesn't report the visibility, so
+// the archived assets are listed once if any hash exists (see loadArchivedIDs).
+func (m *Mapper) bulkCheckExisting(ctx context.Context, hashes []string) (map[string]string, error) {
+	existing := make(map[string]string)
+
+	for start := 0; start < len(hashes); start += bulkCheckBatchSize {
+		end := min(start+bulkCheckBatchSize, len(hashes))
+		batch := hashes[start:end]
+
+		req := bulkCheckRequest{Assets: make([]bulkCheckItem, len(batch))}
+		for i, h := range batch {
+			req.Assets[i] = bulkCheckItem{ID: strconv.Itoa(i), Checksum: h}
+		}
+
+		var resp bulkCheckResponse
+		if err := m.apiRequest(ctx, "POST", "/api/assets/bulk-upload-check", req, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, r := range resp.Results {
+			if r.Action != "reject" || r.Reason != "duplicate" || r.AssetID == "" || (r.IsTrashed && !m.searchTrash) {
+				continue
+			}
+			i, err := strconv.Atoi(r.ID)
+			if err != nil || i < 0 || i >= len(batch) {
+				continue
+			}
+			existing[batch[i]] = r.AssetID
+			if r.IsTrashed {
+				m.trashedIDs[r.AssetID] = true
+			}
+		}
+	}
+
+	if len(existing) > 0 {
+		if err := m.loadArchivedIDs(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			m.logger("Warning: failed to list the archived Immich assets, the visibility of hash matches may be wrong: %v", err)
+		}
+	}
+	return existing, nil
+}
+
+// loadArchivedIDs records the IDs of all archived assets in archivedIDs,
+// including trashed ones with --search-trash. Unlike searchWithVisibility,
+// all pages are read.
+func (m *Mapper) loadArchivedIDs(ctx context.Context) error {
+	query := map[string]interface{}{"size": archivePageSize}
+	m.compat.applyVisibility(query, VisibilityArchive)
+	if m.searchTrash {
+		query["withDeleted"] = true
+	}
+
+	for page := 1; ; page++ {
+		query["page"] = page
+		var result searchMetadataResponse
+		if err := m.apiRequest(ctx, "POST", "/api/search/metadata", query, &result); err != nil {
+			return err
+		}
+		for _, a := range result.Assets.Items {
+			m.archivedIDs[a.ID] = true
+		}
+		if len(result.Assets.Items) < archivePageSize || result.Assets.NextPage == nil {
+			return nil
+		}
+	}
+}
+
+// getAsset fetches the details of a single Immich asset.
+func (m *Mapper) getAsset(ctx context.Context, id string) (*immich.Asset, error) {
+	var asset immich.Asset
+	if err := m.apiRequest(ctx, "GET", "/api/assets/"+url.PathEscape(id), nil, &asset); err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
@@ -0,0 +1,95 @@
+package mapper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBulkCheckVisibility(t *testing.T) {
+	var searches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("%s: %v", r.URL.Path, err)
+		}
+		switch r.URL.Path {
+		case "/api/assets/bulk-upload-check":
+			writeJSON(w, `{"results": [
+				{"id": "0", "action": "reject", "reason": "duplicate", "assetId": "timeline-1"},
+				{"id": "1", "action": "reject", "reason": "duplicate", "assetId": "archived-1"},
+				{"id": "2", "action": "accept"}
+			]}`)
+		case "/api/search/metadata":
+			searches++
+			if body["visibility"] != VisibilityArchive || body["size"] != float64(archivePageSize) {
+				t.Errorf("archive search query %v", body)
+			}
+			// Two pages, the first one full
+			if body["page"] == float64(1) {
+				items := make([]map[string]string, archivePageSize)
+				for i := range items {
+					items[i] = map[string]string{"id": "other"}
+				}
+				items[0]["id"] = "archived-1"
+				data, _ := json.Marshal(map[string]interface{}{"assets": map[string]interface{}{"items": items, "nextPage": "2"}})
+				w.Write(data)
+				return
+			}
+			writeJSON(w, `{"assets": {"items": [{"id": "archived-2"}], "nextPage": null}}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	m, err := New(Config{Server: srv.URL, APIKey: "key", Concurrency: 1, Logger: func(string, ...interface{}) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.compat = compatFor(serverVersion{Major: 1, Minor: 140})
+
+	existing, err := m.bulkCheckExisting(context.Background(), []string{"h-timeline", "h-archived", "h-missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) != 2 || searches != 2 {
+		t.Fatalf("bulk check found %v with %d archive searches, want 2 assets and 2 searches", existing, searches)
+	}
+	for hash, want := range map[string]string{"h-timeline": VisibilityTimeline, "h-archived": VisibilityArchive} {
+		assets := m.runTier(context.Background(), TierHash, candidate{hash: hash}, existing)
+		if len(assets) != 1 || assets[0].Visibility != want {
+			t.Errorf("%s: runTier = %v, want one asset with visibility %s", hash, assets, want)
+		}
+	}
+	if !m.archivedIDs["archived-2"] {
+		t.Error("second page of archived assets not read")
+	}
+}
+
+func TestBulkCheckNothingExisting(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/assets/bulk-upload-check" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		writeJSON(w, `{"results": [{"id": "0", "action": "accept"}]}`)
+	}))
+	defer srv.Close()
+
+	m, err := New(Config{Server: srv.URL, APIKey: "key", Concurrency: 1, Logger: func(string, ...interface{}) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := m.bulkCheckExisting(context.Background(), []string{"h-missing"})
+	if err != nil || len(existing) != 0 {
+		t.Errorf("bulkCheckExisting = %v, %v, want nothing", existing, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, data string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
+}
@@ -0,0 +1,368 @@
+package mapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+)
+
+// checkpointInterval is how often the checkpoint is written while an input
+// is being matched. It is also written after every input and by Close.
+const checkpointInterval = 30 * time.Second
+
+// checkpoint keeps the progress of a run in a file (--checkpoint), so an
+// interrupted run can be resumed: the inputs that have been processed
+// completely and, of the input being matched, the media files that are
+// done, along with the result and the mapper state up to then.
+//
+// The state is only consistent between media files of the matching phase
+// and between inputs; an input interrupted while it is being collected and
+// hashed is processed again. When an input is resumed, it is collected
+// again without the media files that are done, and the state recorded
+// after its collect phase replaces what that added.
+type checkpoint struct {
+	path       string
+	file       checkpointFile
+	media      map[checkpointMedia]bool // of file.Current
+	orphans    map[string]bool          // of file.Current
+	result     *Result                  // being built by Run
+	resuming   bool                     // file.Current is still to be restored
+	consistent bool                     // result matches the recorded progress
+	dirty      bool
+	saved      time.Time
+}
+
+// checkpointFile is the JSON format of the checkpoint file.
+type checkpointFile struct {
+	// Inputs are the paths of all takeout inputs of the run
+	Inputs []string `json:"inputs"`
+	// Done are the inputs that have been processed completely
+	Done    []string         `json:"done"`
+	Current *checkpointInput `json:"current,omitempty"`
+	State   *checkpointState `json:"state,omitempty"`
+}
+
+// checkpointInput is the progress of the input being matched.
+type checkpointInput struct {
+	Path    string            `json:"path"`
+	Media   []checkpointMedia `json:"media"`
+	Orphans []string          `json:"orphans"`
+}
+
+// checkpointMedia is a media file that has been matched, by the JSON file
+// it was resolved from, as edited copies and the assets of an album JSON
+// share one.
+type checkpointMedia struct {
+	JSONFile string `json:"json_file"`
+	Path     string `json:"path"`
+}
+
+// checkpointState is the state of the run that Run builds its output from.
+type checkpointState struct {
+	Result *Result `json:"result"`
+	// InputStats are the stats before the current input, see addArchiveStats
+	InputStats       Stats              `json:"input_stats"`
+	Albums           checkpointAlbums   `json:"albums"`
+	Matched          []checkpointMatch  `json:"matched,omitempty"`
+	MissingOrphans   []checkpointOrphan `json:"missing_orphans,omitempty"`
+	BrowserListed    map[string]int     `json:"browser_listed,omitempty"`
+	BrowserProcessed map[string]int     `json:"browser_processed,omitempty"`
+}
+
+// checkpointAlbums is an albumPlan.
+type checkpointAlbums struct {
+	Titles map[string]string   `json:"titles"`
+	Assets map[string][]string `json:"assets"`
+	Shared map[string]bool     `json:"shared"`
+	Total  map[string]int      `json:"total"`
+}
+
+// checkpointMatch is a matchedAsset, by Immich asset ID.
+type checkpointMatch struct {
+	ImmichID  string           `json:"immich_id"`
+	ImmichURL string           `json:"immich_url"`
+	Hashes    []checkpointHash `json:"hashes"`
+}
+
+// checkpointHash is a matchedHash.
+type checkpointHash struct {
+	Hash      string `json:"hash"`
+	GoogleURL string `json:"google_url"`
+	Method    string `json:"method"`
+}
+
+// checkpointOrphan is a missingOrphan.
+type checkpointOrphan struct {
+	Archive string `json:"archive"`
+	Path    string `json:"path"`
+}
+
+// loadCheckpoint reads the checkpoint file at path for a run over inputs.
+// A missing file starts a new run. A checkpoint of other inputs is an
+// error, so it isn't mixed into the result by accident.
+func loadCheckpoint(path string, inputs []string) (*checkpoint, error) {
+	c := &checkpoint{
+		path:    path,
+		file:    checkpointFile{Inputs: inputs, Done: make([]string, 0)},
+		media:   make(map[checkpointMedia]bool),
+		orphans: make(map[string]bool),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var file checkpointFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	if !slices.Equal(file.Inputs, inputs) {
+		return nil, fmt.Errorf("checkpoint %s is of a run over other takeout files; remove it to start over", path)
+	}
+	if file.State == nil || file.State.Result == nil {
+		return c, nil
+	}
+	c.file = file
+	if c.file.Done == nil {
+		c.file.Done = make([]string, 0)
+	}
+	if file.Current != nil {
+		for _, media := range file.Current.Media {
+			c.media[media] = true
+		}
+		for _, o := range file.Current.Orphans {
+			c.orphans[o] = true
+		}
+		c.resuming = true
+	}
+	return c, nil
+}
+
+// resumeCheckpoint starts the run from the checkpoint: inputs that are done
+// are skipped, and unless an input is resumed, the recorded state is
+// restored right away.
+func (m *Mapper) resumeCheckpoint(result *Result) {
+	c := m.checkpoint
+	c.result = result
+	if c.file.State == nil {
+		c.consistent = true
+		return
+	}
+
+	pending := m.inputs[:0:0]
+	for _, input := range m.inputs {
+		if !slices.Contains(c.file.Done, input.Path) {
+			pending = append(pending, input)
+		}
+	}
+	m.logger("Resuming from checkpoint %s: %d of %d inputs done", c.path, len(m.inputs)-len(pending), len(m.inputs))
+	m.inputs = pending
+
+	if !c.resuming {
+		m.restoreCheckpoint()
+	}
+}
+
+// checkpointCollected records that the current input has been collected.
+// If it is the input being resumed, the recorded state is restored.
+func (m *Mapper) checkpointCollected() {
+	c := m.checkpoint
+	if c == nil {
+		return
+	}
+	if c.resuming && c.file.Current.Path == m.input {
+		m.logger("Resuming %s: %d media files and %d orphans done", m.input, len(c.media), len(c.orphans))
+		m.restoreCheckpoint()
+		return
+	}
+	c.file.Current = &checkpointInput{Path: m.input, Media: make([]checkpointMedia, 0), Orphans: make([]string, 0)}
+	c.media = make(map[checkpointMedia]bool)
+	c.orphans = make(map[string]bool)
+	c.consistent = true
+	c.dirty = true
+}
+
+// startCheckpointInput records that an input is being collected, which
+// changes the result before the progress can be recorded.
+func (m *Mapper) startCheckpointInput() {
+	if m.checkpoint != nil {
+		m.checkpoint.consistent = false
+	}
+}
+
+// finishCheckpointInput records that the current input has been processed
+// completely and writes the checkpoint.
+func (m *Mapper) finishCheckpointInput() {
+	c := m.checkpoint
+	if c == nil {
+		return
+	}
+	c.file.Done = append(c.file.Done, m.input)
+	c.file.Current = nil
+	c.consistent = true
+	c.dirty = true
+	if err := m.saveCheckpoint(); err != nil {
+		m.logger("Warning: %v", err)
+	}
+}
+
+// checkpointed returns true if a media file of the input being resumed has
+// been matched already. jsonFile is empty for orphan media.
+func (m *Mapper) checkpointed(jsonFile, mediaPath string) bool {
+	c := m.checkpoint
+	if c == nil || !c.resuming || c.file.Current.Path != m.input {
+		return false
+	}
+	if jsonFile == "" {
+		return c.orphans[mediaPath]
+	}
+	return c.media[checkpointMedia{jsonFile, mediaPath}]
+}
+
+// markCheckpoint records that a media file has been matched, and writes the
+// checkpoint if it is due. jsonFile is empty for orphan media.
+func (m *Mapper) markCheckpoint(jsonFile, mediaPath string) {
+	c := m.checkpoint
+	if c == nil || c.file.Current == nil {
+		return
+	}
+	if jsonFile == "" {
+		c.orphans[mediaPath] = true
+		c.file.Current.Orphans = append(c.file.Current.Orphans, mediaPath)
+	} else {
+		media := checkpointMedia{jsonFile, mediaPath}
+		c.media[media] = true
+		c.file.Current.Media = append(c.file.Current.Media, media)
+	}
+	c.dirty = true
+	if time.Since(c.saved) >= checkpointInterval {
+		if err := m.saveCheckpoint(); err != nil {
+			m.logger("Warning: %v", err)
+		}
+	}
+}
+
+// restoreCheckpoint replaces the result and the mapper state with the
+// recorded state.
+func (m *Mapper) restoreCheckpoint() {
+	c := m.checkpoint
+	s := c.file.State
+	*c.result = *s.Result
+	m.inputStats = s.InputStats
+
+	m.albums = newAlbumPlan()
+	for dir, title := range s.Albums.Titles {
+		m.albums.titles[dir] = title
+	}
+	for dir, ids := range s.Albums.Assets {
+		m.albums.assets[dir] = ids
+	}
+	for dir := range s.Albums.Shared {
+		m.albums.shared[dir] = true
+	}
+	for dir, n := range s.Albums.Total {
+		m.albums.total[dir] = n
+	}
+
+	m.matched, m.matchedIDs = nil, nil
+	for _, cm := range s.Matched {
+		if m.matched == nil {
+			m.matched = make(map[string]*matchedAsset)
+		}
+		a := &matchedAsset{immichURL: cm.ImmichURL}
+		for _, h := range cm.Hashes {
+			a.hashes = append(a.hashes, matchedHash{h.Hash, h.GoogleURL, h.Method})
+		}
+		m.matched[cm.ImmichID] = a
+		m.matchedIDs = append(m.matchedIDs, cm.ImmichID)
+	}
+
+	m.missingOrphans = nil
+	for _, o := range s.MissingOrphans {
+		m.missingOrphans = append(m.missingOrphans, missingOrphan{archive: o.Archive, path: o.Path})
+	}
+	m.browserListed = s.BrowserListed
+	m.browserProcessed = s.BrowserProcessed
+	if m.browserProcessed == nil {
+		m.browserProcessed = make(map[string]int)
+	}
+
+	c.resuming = false
+	c.consistent = true
+}
+
+// state records the result and the mapper state in the checkpoint file.
+func (c *checkpoint) state(m *Mapper) {
+	s := &checkpointState{
+		Result:     c.result,
+		InputStats: m.inputStats,
+		Albums: checkpointAlbums{
+			Titles: m.albums.titles,
+			Assets: m.albums.assets,
+			Shared: m.albums.shared,
+			Total:  m.albums.total,
+		},
+		BrowserListed:    m.browserListed,
+		BrowserProcessed: m.browserProcessed,
+	}
+	for _, id := range m.matchedIDs {
+		a := m.matched[id]
+		cm := checkpointMatch{ImmichID: id, ImmichURL: a.immichURL}
+		for _, h := range a.hashes {
+			cm.Hashes = append(cm.Hashes, checkpointHash{h.hash, h.googleURL, h.method})
+		}
+		s.Matched = append(s.Matched, cm)
+	}
+	for _, o := range m.missingOrphans {
+		s.MissingOrphans = append(s.MissingOrphans, checkpointOrphan{o.archive, o.path})
+	}
+	c.file.State = s
+}
+
+// saveCheckpoint writes the checkpoint file if there is progress to record
+// and the result is consistent with it. The file is replaced atomically,
+// so an interrupted write doesn't lose the previous checkpoint.
+func (m *Mapper) saveCheckpoint() error {
+	c := m.checkpoint
+	if c == nil || !c.dirty || !c.consistent || c.resuming {
+		return nil
+	}
+	c.state(m)
+	m.statsMu.Lock()
+	data, err := json.Marshal(c.file)
+	m.statsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	c.dirty = false
+	c.saved = time.Now()
+	return nil
+}
+
+// RemoveCheckpoint removes the checkpoint file once the output of a run has
+// been written, so the next run starts over. Without Config.CheckpointFile,
+// it does nothing.
+func (m *Mapper) RemoveCheckpoint() error {
+	c := m.checkpoint
+	if c == nil {
+		return nil
+	}
+	m.checkpoint = nil
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package mapper
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+// checkpointServer is a minimal Immich server for the takeouts of
+// checkpointInputs. If interruptAt is set, the run is canceled on that
+// search or bulk check request.
+type checkpointServer struct {
+	mu          sync.Mutex
+	assets      []map[string]string // id, checksum, originalFileName, visibility
+	requests    int
+	interruptAt int
+	cancel      context.CancelFunc
+}
+
+func (s *checkpointServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case "/api/server/ping":
+		writeJSON(w, `{"res": "pong"}`)
+		return
+	case "/api/users/me":
+		writeJSON(w, `{"id": "u1", "email": "test@example.com"}`)
+		return
+	case "/api/server/media-types":
+		writeJSON(w, `{"image": [".jpg"], "video": [".mp4"], "sidecar": [".xmp"]}`)
+		return
+	case "/api/server/version":
+		writeJSON(w, `{"major": 1, "minor": 140, "patch": 0}`)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests++
+	if s.requests == s.interruptAt && s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/search/metadata":
+		items := make([]map[string]string, 0)
+		for _, a := range s.assets {
+			if v, ok := body["checksum"]; ok && v != a["checksum"] {
+				continue
+			}
+			if v, ok := body["originalFileName"]; ok && v != a["originalFileName"] {
+				continue
+			}
+			if v, ok := body["visibility"]; ok && v != a["visibility"] {
+				continue
+			}
+			items = append(items, a)
+		}
+		data, _ := json.Marshal(map[string]interface{}{"assets": map[string]interface{}{"items": items, "nextPage": nil}})
+		w.Write(data)
+	case "/api/assets/bulk-upload-check":
+		results := make([]map[string]string, 0)
+		for _, item := range body["assets"].([]interface{}) {
+			item := item.(map[string]interface{})
+			res := map[string]string{"id": item["id"].(string), "action": "accept"}
+			for _, a := range s.assets {
+				if a["checksum"] == item["checksum"] {
+					res = map[string]string{"id": item["id"].(string), "action": "reject", "reason": "duplicate", "assetId": a["id"]}
+				}
+			}
+			results = append(results, res)
+		}
+		data, _ := json.Marshal(map[string]interface{}{"results": results})
+		w.Write(data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func sidecarFile(title, url string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(fmt.Sprintf(`{"title": %q, "url": %q, "photoTakenTime": {"timestamp": "1700000000"}}`, title, url))}
+}
+
+// checkpointInputs writes two takeouts to dir and returns their paths and
+// the Immich server that has some of their media files.
+func checkpointInputs(t *testing.T, dir string) ([]string, *checkpointServer) {
+	holiday := "Takeout/Google Photos/Holiday 2023/"
+	family := "Takeout/Google Photos/Family/"
+	photos := "Takeout/Google Photos/Photos from 2023/"
+	inputs := []fstest.MapFS{
+		{
+			"Takeout/archive_browser.html": {Data: []byte("<ul><li>Holiday 2023 (9 items)</li></ul>")},
+			holiday + "metadata.json":      {Data: []byte(`{"title": "Holiday 2023"}`)},
+			holiday + "IMG_0001.jpg":       {Data: []byte("one")},
+			holiday + "IMG_0001.jpg.json":  sidecarFile("IMG_0001.jpg", "https://photos.google.com/photo/P1"),
+			holiday + "IMG_0002.jpg":       {Data: []byte("two")},
+			holiday + "IMG_0002.jpg.json":  sidecarFile("IMG_0002.jpg", "https://photos.google.com/photo/P2"),
+			holiday + "IMG_0003.jpg":       {Data: []byte("three")},
+			holiday + "IMG_0003.jpg.json":  sidecarFile("IMG_0003.jpg", "https://photos.google.com/photo/P3"),
+			holiday + "IMG_0004.jpg":       {Data: []byte("four")},
+			holiday + "IMG_0005.jpg":       {Data: []byte("five")},
+			family + "metadata.json":       {Data: []byte(`{"title": "Family", "access": "protected"}`)},
+			family + "IMG_0006.jpg":        {Data: []byte("six")},
+			family + "IMG_0006.jpg.json":   sidecarFile("IMG_0006.jpg", "https://photos.google.com/photo/P6"),
+		},
+		{
+			photos + "IMG_0101.jpg":        {Data: []byte("hundred one")},
+			photos + "IMG_0101-edited.jpg": {Data: []byte("hundred one, edited")},
+			photos + "IMG_0101.jpg.json":   sidecarFile("IMG_0101.jpg", "https://photos.google.com/photo/P101"),
+			photos + "IMG_0102.jpg":        {Data: []byte("hundred two")},
+			photos + "IMG_0102.jpg.json":   sidecarFile("IMG_0102.jpg", "https://photos.google.com/photo/P102"),
+			photos + "IMG_0103.jpg":        {Data: []byte("hundred three")},
+		},
+	}
+	var paths []string
+	for i, fsys := range inputs {
+		p := filepath.Join(dir, fmt.Sprintf("takeout-%d", i+1))
+		if err := os.CopyFS(p, fsys); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+
+	srv := &checkpointServer{}
+	for id, asset := range map[string]struct{ content, name, visibility string }{
+		"a1":   {"one", "IMG_0001.jpg", VisibilityTimeline},
+		"a2":   {"two", "IMG_0002.jpg", VisibilityArchive},
+		"a4":   {"four", "IMG_0004.jpg", VisibilityTimeline},
+		"a6":   {"six", "IMG_0006.jpg", VisibilityTimeline},
+		"a101": {"hundred one", "IMG_0101.jpg", VisibilityTimeline},
+		"a102": {"hundred one, edited", "IMG_0101-edited.jpg", VisibilityArchive},
+		"a103": {"hundred three", "IMG_0103.jpg", VisibilityTimeline},
+	} {
+		sum := sha1.Sum([]byte(asset.content))
+		srv.assets = append(srv.assets, map[string]string{
+			"id":               id,
+			"checksum":         base64.StdEncoding.EncodeToString(sum[:]),
+			"originalFileName": asset.name,
+			"visibility":       asset.visibility,
+		})
+	}
+	return paths, srv
+}
+
+func checkpointConfig(server string, paths []string, checkpointFile string, concurrency int, progress func(done, total int)) Config {
+	// IMG_0003.jpg is known to be a1, which is also matched by the hash of
+	// IMG_0001.jpg: a suspicious match
+	three := sha1.Sum([]byte("three"))
+	return Config{
+		Server:               server,
+		APIKey:               "key",
+		TakeoutPaths:         paths,
+		CheckpointFile:       checkpointFile,
+		Concurrency:          concurrency,
+		VerifyAgainstBrowser: true,
+		UploadManifest:       true,
+		KnownMap:             map[string]string{base64.StdEncoding.EncodeToString(three[:]): "a1"},
+		Progress:             progress,
+		Logger:               func(string, ...interface{}) {},
+	}
+}
+
+// runCheckpoint runs a mapper and closes it, which writes the checkpoint.
+func runCheckpoint(ctx context.Context, cfg Config) (*Result, error) {
+	m, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.Run(ctx)
+	if closeErr := m.Close(); err == nil {
+		err = closeErr
+	}
+	return result, err
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	paths, server := checkpointInputs(t, dir)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	var steps int
+	want, err := runCheckpoint(context.Background(), checkpointConfig(srv.URL, paths, "", 1, func(done, total int) { steps = total }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := server.requests
+	if len(want.Mappings) != 6 || len(want.NotFound) != 1 || len(want.OrphanMedia) != 3 || len(want.SuspiciousMatches) != 1 ||
+		len(want.BrowserMismatches) != 1 || len(want.UploadManifest.Archives) != 1 || len(want.AlbumPlan) != 2 {
+		t.Fatalf("uninterrupted run: %d mappings, %d not found, %d orphans, %d suspicious, %d browser mismatches, %d archives with missing orphans, %d albums",
+			len(want.Mappings), len(want.NotFound), len(want.OrphanMedia), len(want.SuspiciousMatches), len(want.BrowserMismatches), len(want.UploadManifest.Archives), len(want.AlbumPlan))
+	}
+
+	type interrupt struct {
+		step, request int
+	}
+	var interrupts []interrupt
+	for step := 1; step < steps; step++ {
+		interrupts = append(interrupts, interrupt{step: step})
+	}
+	for request := 1; request <= requests; request++ {
+		interrupts = append(interrupts, interrupt{request: request})
+	}
+
+	var resumed int
+	for _, concurrency := range []int{1, 4} {
+		for _, in := range interrupts {
+			name := fmt.Sprintf("concurrency %d, interrupted at step %d, request %d", concurrency, in.step, in.request)
+			checkpointFile := filepath.Join(dir, "checkpoint.json")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			server.mu.Lock()
+			server.requests, server.interruptAt, server.cancel = 0, in.request, cancel
+			server.mu.Unlock()
+			progress := func(done, total int) {
+				if done == in.step {
+					cancel()
+				}
+			}
+			_, err := runCheckpoint(ctx, checkpointConfig(srv.URL, paths, checkpointFile, concurrency, progress))
+			cancel()
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("%s: interrupted run returned %v", name, err)
+			}
+			// Nothing is recorded while the first input is collected
+			_, statErr := os.Stat(checkpointFile)
+			written := statErr == nil
+
+			server.mu.Lock()
+			server.interruptAt, server.cancel = 0, nil
+			server.mu.Unlock()
+			cfg := checkpointConfig(srv.URL, paths, checkpointFile, concurrency, nil)
+			var resuming atomic.Bool
+			cfg.Logger = func(format string, args ...interface{}) {
+				if strings.HasPrefix(format, "Resuming from checkpoint") {
+					resuming.Store(true)
+				}
+			}
+			m, err := New(cfg)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			got, err := m.Run(context.Background())
+			if err != nil {
+				t.Fatalf("%s: resumed run returned %v", name, err)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.RemoveCheckpoint(); err != nil {
+				t.Fatal(err)
+			}
+			if resuming.Load() != written {
+				t.Errorf("%s: checkpoint written %v, resumed %v", name, written, resuming.Load())
+			}
+			if resuming.Load() {
+				resumed++
+			}
+			compareResults(t, name, got, want)
+		}
+	}
+	// Most interrupts are after the first input has been collected
+	if resumed < len(interrupts) {
+		t.Errorf("only %d of the %d interrupted runs were resumed from a checkpoint", resumed, 2*len(interrupts))
+	}
+}
+
+// compareResults compares two results as they are written, including the
+// parts that are written to separate files. The orphans are compared by
+// path, as they are only sorted with Config.Deterministic.
+func compareResults(t *testing.T, name string, got, want *Result) {
+	t.Helper()
+	byPath := func(a, b OrphanMedia) int { return strings.Compare(a.Path, b.Path) }
+	for _, r := range []**Result{&got, &want} {
+		sorted := **r
+		sorted.OrphanMedia = slices.Clone(sorted.OrphanMedia)
+		slices.SortFunc(sorted.OrphanMedia, byPath)
+		*r = &sorted
+	}
+	for _, part := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"result", got, want},
+		{"album plan", got.AlbumPlan, want.AlbumPlan},
+		{"cleanup report", got.Cleanup, want.Cleanup},
+		{"upload manifest", got.UploadManifest, want.UploadManifest},
+	} {
+		gotJSON, err := json.Marshal(part.got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantJSON, err := json.Marshal(part.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gotJSON, wantJSON) {
+			t.Errorf("%s: resumed %s differs:\n%s\nwant\n%s", name, part.name, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestCheckpointOtherInputs(t *testing.T) {
+	dir := t.TempDir()
+	paths, server := checkpointInputs(t, dir)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	// In the second input, after the first one has been recorded
+	progress := func(done, total int) {
+		if done == total-1 {
+			cancel()
+		}
+	}
+	if _, err := runCheckpoint(ctx, checkpointConfig(srv.URL, paths, checkpointFile, 1, progress)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("interrupted run returned %v", err)
+	}
+	if _, err := os.Stat(checkpointFile); err != nil {
+		t.Fatalf("no checkpoint written: %v", err)
+	}
+
+	for _, other := range [][]string{paths[:1], {paths[1], paths[0]}, append(paths, filepath.Join(dir, "takeout-1"))} {
+		_, err := New(checkpointConfig(srv.URL, other, checkpointFile, 1, nil))
+		if err == nil || !strings.Contains(err.Error(), "other takeout files") {
+			t.Errorf("New over %v = %v, want the checkpoint refused", other, err)
+		}
+	}
+	if _, err := New(checkpointConfig(srv.URL, paths, checkpointFile, 1, nil)); err != nil {
+		t.Errorf("New over the same inputs: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package mapper
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"hash"
+	"io"
+	"io/fs"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/simulot/immich-go/immich"
+)
+
+// checksumAlgorithm is a digest of the file content that Immich can search by,
+// in addition to the SHA1 "checksum" every version has.
+type checksumAlgorithm struct {
+	field string // search filter and asset field with the base64 digest
+	new   func() hash.Hash
+}
+
+// Hash algorithms of the hash in the output (Config.HashAlgo). Immich
+// identifies assets by the SHA1 of the original file ("checksum"), so the
+// search always uses SHA1; the others are for comparing with tools that
+// store a different digest.
+const (
+	HashAlgoSHA1   = "sha1"
+	HashAlgoMD5    = "md5"
+	HashAlgoXXHash = "xxhash" // XXH64
+)
+
+// outputHashes creates the digests of the output hash algorithms besides SHA1.
+var outputHashes = map[string]func() hash.Hash{
+	HashAlgoMD5:    md5.New,
+	HashAlgoXXHash: func() hash.Hash { return xxhash.New() },
+}
+
+// DefaultHashBufferSize is the default size of the read buffer for hashing.
+// Larger reads help on high-latency storage like network shares and
+// spinning disks; on local SSDs the size makes no measurable difference.
+const DefaultHashBufferSize = 1 << 20
+
+// hashBuffer returns a read buffer for hashing; put it back into
+// m.hashBuffers when done.
+func (m *Mapper) hashBuffer() *[]byte {
+	if buf, ok := m.hashBuffers.Get().(*[]byte); ok {
+		return buf
+	}
+	buf := make([]byte, m.hashBufferSize)
+	return &buf
+}
+
+// ctxReader fails reads once its context is canceled, so hashing a large
+// file stops promptly.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// computeHash computes the SHA1 hash of a file and returns it as base64.
+// The SHA1 hash identifies the file everywhere (search, known maps, bulk
+// check). If the server supports further checksums (searchCompat.checksums),
+// they are computed in the same pass and kept for searchAssetsByHash, as is
+// the hash of another --hash-algo for the output (see outputHash).
+// With --cache-file, hashes of files with an unchanged size are taken from
+// the cache. It is safe for concurrent use.
+func (m *Mapper) computeHash(ctx context.Context, fsys fs.FS, fpath string) (string, error) {
+	size := int64(-1)
+	if m.hashCache != nil && m.input != "" {
+		if info, err := fs.Stat(fsys, fpath); err == nil {
+			size = info.Size()
+		}
+		if e, ok := m.hashCache.lookup(m.cacheInput(), fpath, size); ok && m.hasDigests(e) {
+			m.setDigests(e.SHA1, e.Digests)
+			return e.SHA1, nil
+		}
+	}
+
+	f, err := fsys.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sha := sha1.New()
+	writers := []io.Writer{sha}
+	extra := make([]hash.Hash, len(m.compat.checksums))
+	for i, algo := range m.compat.checksums {
+		extra[i] = algo.new()
+		writers = append(writers, extra[i])
+	}
+	var out hash.Hash
+	if newHash, ok := outputHashes[m.hashAlgo]; ok {
+		out = newHash()
+		writers = append(writers, out)
+	}
+	buf := m.hashBuffer()
+	defer m.hashBuffers.Put(buf)
+	// ctxReader also hides WriterTo, which os.File implements, so the buffer is always used
+	if _, err := io.CopyBuffer(io.MultiWriter(writers...), ctxReader{ctx, f}, *buf); err != nil {
+		return "", err
+	}
+
+	sum := base64.StdEncoding.EncodeToString(sha.Sum(nil))
+	var digests map[string]string
+	if len(extra) > 0 {
+		digests = make(map[string]string, len(extra))
+		for i, algo := range m.compat.checksums {
+			digests[algo.field] = base64.StdEncoding.EncodeToString(extra[i].Sum(nil))
+		}
+	}
+	if out != nil {
+		if digests == nil {
+			digests = make(map[string]string, 1)
+		}
+		digests[m.hashAlgo] = base64.StdEncoding.EncodeToString(out.Sum(nil))
+	}
+	m.setDigests(sum, digests)
+	if size >= 0 {
+		if err := m.hashCache.store(m.cacheInput(), fpath, hashCacheEntry{Size: size, SHA1: sum, Digests: digests}); err != nil {
+			m.logger("Warning: %v", err)
+		}
+	}
+	return sum, nil
+}
+
+// hasDigests returns true if a cache entry has all further checksums the
+// server supports and the output hash; otherwise the file is hashed again.
+func (m *Mapper) hasDigests(e hashCacheEntry) bool {
+	for _, algo := range m.compat.checksums {
+		if _, ok := e.Digests[algo.field]; !ok {
+			return false
+		}
+	}
+	if _, ok := outputHashes[m.hashAlgo]; ok {
+		if _, ok := e.Digests[m.hashAlgo]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// outputHash returns the hash of a file in the output: the SHA1 hash sum,
+// or the digest of the --hash-algo computed along with it.
+func (m *Mapper) outputHash(sum string) string {
+	if _, ok := outputHashes[m.hashAlgo]; !ok || sum == "" {
+		return sum
+	}
+	m.digestsMu.Lock()
+	defer m.digestsMu.Unlock()
+	return m.digests[sum][m.hashAlgo]
+}
+
+// setDigests keeps the further checksums of a file for searchAssetsByHash
+// and the output hash.
+func (m *Mapper) setDigests(sum string, digests map[string]string) {
+	if len(digests) == 0 {
+		return
+	}
+	m.digestsMu.Lock()
+	m.digests[sum] = digests
+	m.digestsMu.Unlock()
+}
+
+// searchAssetsByHash searches for assets by hash across timeline and archive
+// (see searchVisibilities). If nothing is found by SHA1, the further
+// checksums computed for the file, if any, are tried in order.
+func (m *Mapper) searchAssetsByHash(ctx context.Context, hash string) ([]*immich.Asset, error) {
+	assets, err := m.searchVisibilities(ctx, func() map[string]interface{} {
+		return map[string]interface{}{"checksum": hash}
+	})
+	if err != nil || len(assets) > 0 {
+		return assets, err
+	}
+
+	m.digestsMu.Lock()
+	digests := m.digests[hash]
+	m.digestsMu.Unlock()
+	for _, algo := range m.compat.checksums {
+		digest, ok := digests[algo.field]
+		if !ok {
+			continue
+		}
+		assets, err := m.searchVisibilities(ctx, func() map[string]interface{} {
+			return map[string]interface{}{algo.field: digest}
+		})
+		if err != nil || len(assets) > 0 {
+			return assets, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,69 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+)
+
+// serverVersion is the Immich server version as reported by the API.
+type serverVersion struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+func (v serverVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// atLeast returns true if v is the given version or newer.
+func (v serverVersion) atLeast(major, minor int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	return v.Minor >= minor
+}
+
+// searchCompat describes how search queries are built for a server version.
+type searchCompat struct {
+	// visibilityField is true for servers that filter by "visibility"
+	// ("timeline", "archive", ...). Older servers use "isArchived" instead.
+	visibilityField bool
+	// checksums lists the checksums besides SHA1 the server can search by,
+	// tried in order if the SHA1 search finds nothing. Immich only has
+	// SHA1 so far; new algorithms are added in compatFor with the version
+	// that introduced them.
+	checksums []checksumAlgorithm
+}
+
+// compatFor returns the search compatibility settings for a server version.
+func compatFor(v serverVersion) searchCompat {
+	return searchCompat{
+		// The visibility enum replaced isArchived in Immich v1.133.
+		visibilityField: v.atLeast(1, 133),
+	}
+}
+
+// applyVisibility adds the visibility filter to a search query.
+func (c searchCompat) applyVisibility(query map[string]interface{}, visibility string) {
+	if c.visibilityField {
+		query["visibility"] = visibility
+		return
+	}
+	query["isArchived"] = visibility == "archive"
+}
+
+// detectServerVersion queries the server version and selects the matching
+// search compatibility settings. Servers before v1.118 only expose the
+// version under /api/server-info.
+func (m *Mapper) detectServerVersion(ctx context.Context) (serverVersion, error) {
+	var v serverVersion
+	err := m.apiRequest(ctx, "GET", "/api/server/version", nil, &v)
+	if err != nil {
+		if legacyErr := m.apiRequest(ctx, "GET", "/api/server-info/version", nil, &v); legacyErr != nil {
+			return serverVersion{}, err
+		}
+	}
+	m.compat = compatFor(v)
+	return v, nil
+}
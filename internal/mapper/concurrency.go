@@ -0,0 +1,83 @@
+package mapper
+
+import (
+	"context"
+	"sync"
+)
+
+// runOrdered calls work for the indexes 0..n-1 on up to workers goroutines
+// and done with the results in index order, on the calling goroutine. This
+// keeps the recording of results (stats, output order, callbacks) exactly as
+// in a sequential run. work must not modify shared state.
+//
+// If done returns an error or ctx is canceled, no further work is started
+// and runOrdered returns after the running calls have finished, so none of
+// them outlives the takeout filesystem they read from. Results that are
+// ready after ctx is canceled aren't passed to done, as the calls may have
+// been cut short.
+func runOrdered[T any](ctx context.Context, workers, n int, work func(ctx context.Context, i int) T, done func(i int, v T) error) error {
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			v := work(ctx, i)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := done(i, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// The results are queued in index order; sem limits the running calls
+	// and pending how far the workers may get ahead of done.
+	sem := make(chan struct{}, workers)
+	pending := make(chan chan T, 2*workers)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		for i := 0; i < n; i++ {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			res := make(chan T, 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res <- work(ctx, i)
+				<-sem
+			}()
+			select {
+			case pending <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	i := 0
+	for res := range pending {
+		v := <-res
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := done(i, v); err != nil {
+			return err
+		}
+		i++
+	}
+	return ctx.Err()
+}
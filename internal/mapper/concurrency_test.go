@@ -0,0 +1,124 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunOrderedOrder(t *testing.T) {
+	tests := []struct {
+		workers, n int
+	}{
+		{0, 5},
+		{1, 20},
+		{2, 20},
+		{4, 20},
+		{8, 3},
+		{4, 0},
+	}
+	for _, tt := range tests {
+		var seen []int
+		err := runOrdered(context.Background(), tt.workers, tt.n, func(ctx context.Context, i int) int {
+			// Later indexes finish first
+			time.Sleep(time.Duration((tt.n-i)%5) * time.Millisecond)
+			return i * 10
+		}, func(i int, v int) error {
+			if v != i*10 {
+				t.Errorf("workers %d: done(%d) got %d", tt.workers, i, v)
+			}
+			seen = append(seen, i)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("workers %d: %v", tt.workers, err)
+		}
+		if len(seen) != tt.n {
+			t.Fatalf("workers %d: done called %d times, want %d", tt.workers, len(seen), tt.n)
+		}
+		for i, got := range seen {
+			if got != i {
+				t.Fatalf("workers %d: done order %v", tt.workers, seen)
+			}
+		}
+	}
+}
+
+func TestRunOrderedDoneError(t *testing.T) {
+	errStop := errors.New("stop")
+	for _, workers := range []int{1, 4} {
+		var running, started atomic.Int32
+		var seen []int
+		err := runOrdered(context.Background(), workers, 100, func(ctx context.Context, i int) int {
+			started.Add(1)
+			running.Add(1)
+			defer running.Add(-1)
+			time.Sleep(time.Millisecond)
+			return i
+		}, func(i int, v int) error {
+			seen = append(seen, i)
+			if i == 3 {
+				return errStop
+			}
+			return nil
+		})
+		if !errors.Is(err, errStop) {
+			t.Errorf("workers %d: err = %v, want %v", workers, err, errStop)
+		}
+		if running.Load() != 0 {
+			t.Errorf("workers %d: %d calls still running after return", workers, running.Load())
+		}
+		if len(seen) != 4 || seen[3] != 3 {
+			t.Errorf("workers %d: done called for %v, want 0 to 3", workers, seen)
+		}
+		// Workers may be ahead of done by the queue, but no further
+		if n := started.Load(); n > int32(4+3*workers) {
+			t.Errorf("workers %d: %d calls started after the error", workers, n)
+		}
+	}
+}
+
+func TestRunOrderedCancel(t *testing.T) {
+	for _, workers := range []int{0, 1, 2, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var running atomic.Int32
+		var seen []int
+		doneOne := make(chan struct{})
+		err := runOrdered(ctx, workers, 50, func(ctx context.Context, i int) int {
+			running.Add(1)
+			defer running.Add(-1)
+			if i == 2 {
+				// Canceled while the call runs: its result is dropped
+				<-doneOne
+				cancel()
+				return i
+			}
+			if i > 2 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+					t.Errorf("workers %d: call %d not canceled", workers, i)
+				}
+			}
+			return i
+		}, func(i int, v int) error {
+			seen = append(seen, i)
+			if i == 1 {
+				close(doneOne)
+			}
+			return nil
+		})
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("workers %d: err = %v, want %v", workers, err, context.Canceled)
+		}
+		if len(seen) != 2 || seen[0] != 0 || seen[1] != 1 {
+			t.Errorf("workers %d: done called for %v, want 0 and 1", workers, seen)
+		}
+		if running.Load() != 0 {
+			t.Errorf("workers %d: %d calls still running after return", workers, running.Load())
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package mapper
+
+import (
+	"path"
+	"strings"
+)
+
+// editedSuffixes are the suffixes Google adds to the name of edited copies,
+// in the languages of the export: "IMG_1234.jpg" -> "IMG_1234-edited.jpg".
+// Edited copies have no sidecar of their own and share the original's.
+var editedSuffixes = []string{
+	"-edited",     // English
+	"-bearbeitet", // German
+	"-modifié",    // French
+	"-editado",    // Spanish, Portuguese
+	"-modificato", // Italian
+	"-bewerkt",    // Dutch
+}
+
+// findEditedFiles returns the edited copies of a media file among the files
+// of its folder, compared like in findMediaFile.
+func findEditedFiles(mediaFile string, filesInDir []string) []string {
+	name := normalizeName(mediaFile)
+	if name == "" {
+		return nil
+	}
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	var edited []string
+	for _, suffix := range editedSuffixes {
+		for _, f := range filesInDir {
+			if normalizeName(f) == stem+suffix+ext {
+				edited = append(edited, f)
+				break
+			}
+		}
+	}
+	return edited
+}
@@ -0,0 +1,77 @@
+package mapper
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFindEditedFiles(t *testing.T) {
+	files := []string{"IMG_1234.jpg", "IMG_1234-edited.jpg", "IMG_1234-bearbeitet.jpg", "IMG_12345-edited.jpg", "IMG_1234-edited.png"}
+	got := findEditedFiles("IMG_1234.jpg", files)
+	want := []string{"IMG_1234-edited.jpg", "IMG_1234-bearbeitet.jpg"}
+	if !slices.Equal(got, want) {
+		t.Errorf("findEditedFiles = %v, want %v", got, want)
+	}
+	if got := findEditedFiles("IMG_9999.jpg", files); got != nil {
+		t.Errorf("findEditedFiles without edited copy = %v, want none", got)
+	}
+}
+
+func TestFindMediaFileEditedOnly(t *testing.T) {
+	m := &Mapper{}
+	for _, edited := range []string{"IMG_1234-edited.jpg", "IMG_1234-bearbeitet.jpg"} {
+		files := []string{"other.jpg", edited}
+		if got := m.findMediaFile("IMG_1234.jpg.json", "IMG_1234.jpg", files); got != edited {
+			t.Errorf("findMediaFile without original = %q, want %q", got, edited)
+		}
+	}
+}
+
+func TestCollectEditedCopy(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Takeout/Google Photos/Photos from 2023/IMG_1234.jpg":        {Data: []byte("original")},
+		"Takeout/Google Photos/Photos from 2023/IMG_1234-edited.jpg": {Data: []byte("edited")},
+		"Takeout/Google Photos/Photos from 2023/IMG_1234.jpg.json": {Data: []byte(`{
+			"title": "IMG_1234.jpg",
+			"url": "https://photos.google.com/photo/AAA",
+			"photoTakenTime": {"timestamp": "1700000000"}
+		}`)},
+	}
+	m, err := New(Config{Server: "http://127.0.0.1:2283", APIKey: "key", Concurrency: 1, Logger: func(string, ...interface{}) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &Result{}
+	candidates, orphans, err := m.collect(context.Background(), fsys, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(candidates) != 2 {
+		t.Fatalf("collect returned %d candidates, want 2", len(candidates))
+	}
+	dir := "Takeout/Google Photos/Photos from 2023/"
+	for i, want := range []struct {
+		mediaPath string
+		edited    bool
+	}{{dir + "IMG_1234.jpg", false}, {dir + "IMG_1234-edited.jpg", true}} {
+		c := candidates[i]
+		if c.mediaPath != want.mediaPath || c.edited != want.edited {
+			t.Errorf("candidate %d = %s (edited %v), want %s (edited %v)", i, c.mediaPath, c.edited, want.mediaPath, want.edited)
+		}
+		if c.md.URL != "https://photos.google.com/photo/AAA" || c.hash == "" {
+			t.Errorf("candidate %d has URL %q and hash %q", i, c.md.URL, c.hash)
+		}
+	}
+	if candidates[0].hash == candidates[1].hash {
+		t.Error("original and edited copy have the same hash")
+	}
+	if len(orphans) != 0 {
+		t.Errorf("collect returned orphans %v, want none", orphans)
+	}
+	if result.Stats.TotalGoogleURLs != 1 || result.Stats.EditedMedia != 1 {
+		t.Errorf("stats: %d Google URLs, %d edited, want 1 and 1", result.Stats.TotalGoogleURLs, result.Stats.EditedMedia)
+	}
+}
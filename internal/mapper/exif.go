@@ -0,0 +1,137 @@
+package mapper
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/simulot/immich-go/immich"
+)
+
+// ExifDiff is an EXIF field whose value in Immich differs from the takeout file.
+type ExifDiff struct {
+	Field   string `json:"field"`
+	Takeout string `json:"takeout"`
+	Immich  string `json:"immich"`
+}
+
+// exifFields are the EXIF fields compared with --compare-exif and used as
+// camera fingerprint by TierCameraFingerprint.
+type exifFields struct {
+	make        string
+	model       string
+	lens        string // LensModel, only used by TierCameraFingerprint
+	orientation string
+	taken       time.Time // DateTimeOriginal as wall-clock time in UTC, like Immich's localDateTime
+}
+
+// exifExtensions lists the formats whose EXIF data can be read.
+var exifExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".tif": true, ".tiff": true}
+
+// readExif reads the compared EXIF fields of a takeout file. It returns nil
+// for formats without readable EXIF data.
+func readExif(fsys fs.FS, fpath string) (*exifFields, error) {
+	if !exifExtensions[strings.ToLower(path.Ext(fpath))] {
+		return nil, nil
+	}
+
+	f, err := fsys.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	x, err := exif.Decode(f)
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return nil, err
+	}
+
+	var fields exifFields
+	fields.make = exifString(x, exif.Make)
+	fields.model = exifString(x, exif.Model)
+	fields.lens = exifString(x, exif.LensModel)
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if v, err := tag.Int(0); err == nil {
+			fields.orientation = strconv.Itoa(v)
+		}
+	}
+	if s := exifString(x, exif.DateTimeOriginal); s != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05", s); err == nil {
+			fields.taken = t
+		}
+	}
+	return &fields, nil
+}
+
+// exifString returns a string field, without padding.
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// diffExif compares the EXIF fields of a takeout file with an Immich asset.
+// Fields missing in the takeout file are not compared.
+func (m *Mapper) diffExif(takeout *exifFields, asset *immich.Asset) []ExifDiff {
+	var diffs []ExifDiff
+	add := func(field, want, got string) {
+		diffs = append(diffs, ExifDiff{Field: field, Takeout: want, Immich: got})
+	}
+
+	info := asset.ExifInfo
+	if takeout.make != "" && !strings.EqualFold(takeout.make, strings.TrimSpace(info.Make)) {
+		add("make", takeout.make, info.Make)
+	}
+	if takeout.model != "" && !strings.EqualFold(takeout.model, strings.TrimSpace(info.Model)) {
+		add("model", takeout.model, info.Model)
+	}
+	// Immich leaves out the default orientation
+	if takeout.orientation != "" && takeout.orientation != info.Orientation && !(takeout.orientation == "1" && info.Orientation == "") {
+		add("orientation", takeout.orientation, info.Orientation)
+	}
+	if !takeout.taken.IsZero() {
+		const layout = "2006-01-02 15:04:05"
+		if info.DateTimeOriginal.IsZero() {
+			add("date_time_original", takeout.taken.Format(layout), "")
+		} else {
+			got := wallClock(info.DateTimeOriginal.Time, assetLocation(asset, m.timezone))
+			if diff := got.Sub(takeout.taken); diff > time.Second || diff < -time.Second {
+				add("date_time_original", takeout.taken.Format(layout), got.Format(layout))
+			}
+		}
+	}
+	return diffs
+}
+
+// checkExif fetches the matched Immich asset and records the EXIF fields
+// that differ from the takeout file on the mapping.
+func (m *Mapper) checkExif(ctx context.Context, c candidate, assetID string, mapping *Mapping, result *Result) {
+	if c.exif == nil {
+		return
+	}
+	asset, err := m.getAsset(ctx, assetID)
+	if err != nil {
+		m.logger("Warning: failed to fetch Immich asset %s to compare EXIF data: %v", assetID, err)
+		return
+	}
+
+	mapping.ExifDiff = m.diffExif(c.exif, asset)
+	if len(mapping.ExifDiff) > 0 {
+		m.incr(&result.Stats.ExifMismatch)
+		fields := make([]string, len(mapping.ExifDiff))
+		for i, d := range mapping.ExifDiff {
+			fields[i] = d.Field
+		}
+		m.logger("Warning: EXIF data differs in Immich for %s: %s", c.mediaPath, strings.Join(fields, ", "))
+	}
+}
@@ -0,0 +1,116 @@
+package mapper
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filename variants of the filename tiers. Each one derives further names to
+// search from the takeout filename, which are tried in the configured order
+// after the filename itself. Immich often stores names differently than the
+// takeout: Google adds "(1)" to re-downloaded files, and import pipelines
+// convert HEIC to JPEG or add prefixes.
+const (
+	FilenameVariantBase      = "base"      // without extension: "IMG_0001.jpg" -> "IMG_0001"
+	FilenameVariantCounter   = "counter"   // without "(N)": "IMG_0001(1).jpg" -> "IMG_0001.jpg"
+	FilenameVariantExtension = "extension" // HEIC <-> JPEG: "IMG_0001.HEIC" -> "IMG_0001.jpg"
+	FilenameVariantPrefix    = "prefix"    // strip or add Config.FilenamePrefix
+	FilenameVariantCase      = "case"      // lower case: "IMG_0001.JPG" -> "img_0001.jpg"
+)
+
+// knownFilenameVariants lists all supported filename variants.
+var knownFilenameVariants = []string{FilenameVariantBase, FilenameVariantCounter, FilenameVariantExtension, FilenameVariantPrefix, FilenameVariantCase}
+
+// DefaultFilenameVariants are the variants used without an explicit list.
+var DefaultFilenameVariants = []string{FilenameVariantBase, FilenameVariantCounter}
+
+// uniqueFilenameVariants are the variants that are likely to hit a
+// different photo, so their names only count if they match a single asset.
+var uniqueFilenameVariants = map[string]bool{FilenameVariantCounter: true, FilenameVariantExtension: true, FilenameVariantPrefix: true}
+
+// ParseFilenameVariants parses a comma-separated list of filename variants.
+func ParseFilenameVariants(s string) ([]string, error) {
+	variants := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, t := range knownFilenameVariants {
+			known = known || t == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown filename variant %q (supported: %s)", name, strings.Join(knownFilenameVariants, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("filename variant %q listed twice", name)
+		}
+		seen[name] = true
+		variants = append(variants, name)
+	}
+	return variants, nil
+}
+
+// filenameCandidate is a name searched by the filename tiers.
+type filenameCandidate struct {
+	name    string
+	variant string // empty for the filename itself
+	unique  bool   // only an unambiguous match counts
+}
+
+// filenameCandidates returns the names to search for a filename in priority
+// order: the filename itself, then the enabled variants of it.
+// Names that a variant doesn't change are left out, as are duplicates.
+func (m *Mapper) filenameCandidates(filename string) []filenameCandidate {
+	candidates := []filenameCandidate{{name: filename}}
+	seen := map[string]bool{filename: true}
+	for _, variant := range m.filenameVariants {
+		for _, name := range m.filenameVariant(variant, filename) {
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			candidates = append(candidates, filenameCandidate{name: name, variant: variant, unique: uniqueFilenameVariants[variant]})
+		}
+	}
+	return candidates
+}
+
+// filenameVariant returns the names of a single variant of a filename.
+func (m *Mapper) filenameVariant(variant, filename string) []string {
+	ext := path.Ext(filename)
+	switch variant {
+	case FilenameVariantBase:
+		return []string{strings.TrimSuffix(filename, ext)}
+
+	case FilenameVariantCounter:
+		if unsuffixed, ok := stripCounter(filename); ok {
+			return []string{unsuffixed}
+		}
+
+	case FilenameVariantExtension:
+		base := strings.TrimSuffix(filename, ext)
+		switch strings.ToLower(ext) {
+		case ".heic", ".heif":
+			return []string{base + ".jpg", base + ".jpeg"}
+		case ".jpg", ".jpeg":
+			return []string{base + ".heic"}
+		}
+
+	case FilenameVariantPrefix:
+		if m.filenamePrefix == "" {
+			return nil
+		}
+		if stripped, ok := strings.CutPrefix(filename, m.filenamePrefix); ok {
+			return []string{stripped}
+		}
+		return []string{m.filenamePrefix + filename}
+
+	case FilenameVariantCase:
+		return []string{strings.ToLower(filename)}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package mapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hashCacheInterval is how often the hash cache is written while files are
+// being hashed, so a killed run keeps most of its hashes. It is also written
+// by Close.
+const hashCacheInterval = 30 * time.Second
+
+// hashCache keeps the hashes of media files between runs (--cache-file), so
+// unchanged takeout archives aren't hashed again. Entries are keyed by the
+// file name of the takeout input and the path in it; an entry only counts
+// if the file still has the recorded size.
+type hashCache struct {
+	path  string
+	mu    sync.Mutex
+	files map[string]map[string]hashCacheEntry // input name -> path -> entry
+	dirty bool
+	saved time.Time
+}
+
+// hashCacheEntry is the cached hash of a media file.
+type hashCacheEntry struct {
+	Size int64  `json:"size"`
+	SHA1 string `json:"sha1"`
+	// Digests are the further checksums, by search field (see checksumAlgorithm),
+	// and the output hash of another --hash-algo, by algorithm
+	Digests map[string]string `json:"digests,omitempty"`
+}
+
+// hashCacheFile is the JSON format of the cache file.
+type hashCacheFile struct {
+	Inputs map[string]map[string]hashCacheEntry `json:"inputs"`
+}
+
+// loadHashCache reads the cache file at path. A missing file is an empty cache.
+func loadHashCache(path string) (*hashCache, error) {
+	c := &hashCache{path: path, files: make(map[string]map[string]hashCacheEntry), saved: time.Now()}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash cache: %w", err)
+	}
+	var file hashCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse hash cache %s: %w", path, err)
+	}
+	if file.Inputs != nil {
+		c.files = file.Inputs
+	}
+	return c, nil
+}
+
+// lookup returns the cached entry of a file if its size still matches.
+func (c *hashCache) lookup(input, fpath string, size int64) (hashCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.files[input][fpath]
+	return e, ok && e.Size == size
+}
+
+// store records the hash of a file, replacing an outdated entry. The cache
+// file is written if it was last written hashCacheInterval ago.
+func (c *hashCache) store(input, fpath string, e hashCacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.files[input] == nil {
+		c.files[input] = make(map[string]hashCacheEntry)
+	}
+	c.files[input][fpath] = e
+	c.dirty = true
+	if time.Since(c.saved) >= hashCacheInterval {
+		return c.write()
+	}
+	return nil
+}
+
+// save writes the cache file if entries were added.
+func (c *hashCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write()
+}
+
+// write writes the cache file if entries were added; c.mu must be held. The
+// file is replaced atomically, so an interrupted write doesn't lose the
+// previous cache.
+func (c *hashCache) write() error {
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(hashCacheFile{Inputs: c.files})
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write hash cache: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to write hash cache: %w", err)
+	}
+	c.dirty = false
+	c.saved = time.Now()
+	return nil
+}
+
+// cacheInput is the name of the current takeout input in the hash cache:
+// its file name, so the cache stays valid if the archives are moved.
+func (m *Mapper) cacheInput() string {
+	return filepath.Base(m.input)
+}
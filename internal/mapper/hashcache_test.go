@@ -0,0 +1,55 @@
+package mapper
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashCachePeriodicSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	c, err := loadHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not written before the interval has passed
+	if err := c.store("takeout-001.zip", "a.jpg", hashCacheEntry{Size: 1, SHA1: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("cache file written right away: %v", err)
+	}
+
+	c.saved = time.Now().Add(-hashCacheInterval)
+	if err := c.store("takeout-001.zip", "b.jpg", hashCacheEntry{Size: 2, SHA1: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	// As a killed run would leave it
+	loaded, err := loadHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fpath, size := range map[string]int64{"a.jpg": 1, "b.jpg": 2} {
+		if _, ok := loaded.lookup("takeout-001.zip", fpath, size); !ok {
+			t.Errorf("%s missing from the written cache", fpath)
+		}
+	}
+	if c.dirty || time.Since(c.saved) > time.Minute {
+		t.Errorf("after the write: dirty %v, saved %v", c.dirty, c.saved)
+	}
+
+	// Nothing new, nothing written
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unchanged cache written again: %v", err)
+	}
+}
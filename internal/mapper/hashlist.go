@@ -0,0 +1,83 @@
+package mapper
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LoadHashList reads a file with one base64 SHA1 hash per line.
+// Empty lines and lines starting with # are ignored.
+func LoadHashList(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ContainsAny(line, " \t,") {
+			return nil, fmt.Errorf("%s:%d: expected one hash per line, got %q", path, lineNo, line)
+		}
+		hashes[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// LoadKnownMap reads a CSV file of hash,immich_id rows with known matches.
+// Hashes can be hex or base64; a header row and duplicate rows with the same
+// ID are allowed, conflicting IDs for a hash are an error.
+func LoadKnownMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	known := make(map[string]string)
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+
+		if len(known) == 0 && strings.EqualFold(record[0], "hash") {
+			continue // header
+		}
+		hash, err := NormalizeHash(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		id := strings.TrimSpace(record[1])
+		if id == "" {
+			return nil, fmt.Errorf("%s:%d: missing immich_id", path, line)
+		}
+		if prev, ok := known[hash]; ok && prev != id {
+			return nil, fmt.Errorf("%s:%d: hash %s is mapped to both %s and %s", path, line, hash, prev, id)
+		}
+		known[hash] = id
+	}
+	return known, nil
+}
@@ -0,0 +1,150 @@
+package mapper
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHTTPConnections is the default size of the connection pool.
+const defaultHTTPConnections = 4
+
+// Defaults of HTTPOptions.
+const (
+	DefaultRequestTimeout = 30 * time.Second
+	DefaultIdleTimeout    = 90 * time.Second
+	DefaultRetryDelay     = 500 * time.Millisecond
+)
+
+// maxRetryDelay caps the backoff between two attempts of a request.
+const maxRetryDelay = 30 * time.Second
+
+// HTTPOptions tunes the HTTP client for direct API calls. Zero values use
+// the defaults.
+type HTTPOptions struct {
+	// RequestTimeout limits each request including reading the response.
+	// Defaults to DefaultRequestTimeout.
+	RequestTimeout time.Duration
+	// IdleTimeout closes connections that were idle for this long.
+	// Defaults to DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// MaxIdleConns is the number of idle connections kept open.
+	// Defaults to the number of HTTP connections.
+	MaxIdleConns int
+	// MaxConnsPerHost limits the connections to the server, including
+	// active ones. Defaults to no limit.
+	MaxConnsPerHost int
+	// MaxRetries is the number of times a request is repeated after a
+	// network error or a 5xx or 429 response. Defaults to no retries.
+	MaxRetries int
+	// RetryDelay is the wait before the first retry; it doubles with every
+	// further one, with random jitter. Defaults to DefaultRetryDelay.
+	RetryDelay time.Duration
+}
+
+// newHTTPClient creates the client for direct API calls. Idle connections
+// are kept open, as every asset needs one or more searches.
+func newHTTPClient(skipSSL bool, conns int, opts HTTPOptions) *http.Client {
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = DefaultRequestTimeout
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = conns
+	}
+	return &http.Client{
+		Timeout: opts.RequestTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: skipSSL},
+			MaxIdleConns:        opts.MaxIdleConns,
+			MaxIdleConnsPerHost: opts.MaxIdleConns,
+			MaxConnsPerHost:     opts.MaxConnsPerHost,
+			IdleConnTimeout:     opts.IdleTimeout,
+		},
+	}
+}
+
+// warmUp opens the pooled connections up front by pinging the server in
+// parallel, so the TLS handshakes don't slow down the first searches.
+// Failures are ignored; the connections are then opened on demand.
+func (m *Mapper) warmUp(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < m.httpConnections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var pong struct {
+				Res string `json:"res"`
+			}
+			_ = m.apiRequest(ctx, "GET", "/api/server/ping", nil, &pong)
+		}()
+	}
+	wg.Wait()
+}
+
+// statusError is the error of an API response with a non-2xx status.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("API returned status %d", e.code)
+}
+
+// retryable returns true if a failed request may succeed when repeated:
+// after network errors, server errors and rate limiting, but not after
+// client errors like 401 or 403, malformed responses or cancellation.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code >= 500 || status.code == http.StatusTooManyRequests
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr)
+}
+
+// idempotent returns true if a request can be repeated without side
+// effects. Searches are POST requests, but only read; creating an album
+// isn't repeated, as the first attempt may have succeeded.
+func idempotent(method, apiPath string) bool {
+	if method != http.MethodPost {
+		return true
+	}
+	return strings.HasPrefix(apiPath, "/api/search/") || apiPath == "/api/assets/bulk-upload-check"
+}
+
+// retryDelay returns the wait before retry number attempt (from 0): the
+// base delay doubled per attempt, capped at maxRetryDelay, with the upper
+// half randomized so concurrent requests don't retry in lockstep.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base << min(attempt, 16)
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// waitRetry waits before a retry and returns false if ctx is done first.
+func waitRetry(ctx context.Context, delay time.Duration) bool {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
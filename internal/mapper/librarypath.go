@@ -0,0 +1,40 @@
+package mapper
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/simulot/immich-go/immich"
+)
+
+// MethodLibraryPath is the match_method of matches by their path in an
+// Immich external library (--library-root), which are tried before hashing.
+const MethodLibraryPath = "library-path"
+
+// libraryPath returns the originalPath Immich has for a media file of an
+// external library that points at the takeout input.
+func (m *Mapper) libraryPath(mediaPath string) string {
+	return strings.TrimSuffix(m.libraryRoot, "/") + "/" + mediaPath
+}
+
+// searchByLibraryPath searches the asset of a media file by its expected
+// originalPath. Failures are logged; the file is then hashed as usual.
+func (m *Mapper) searchByLibraryPath(ctx context.Context, mediaPath string) []*immich.Asset {
+	originalPath := m.libraryPath(mediaPath)
+	assets, err := m.searchVisibilities(ctx, func() map[string]interface{} {
+		return map[string]interface{}{"originalPath": originalPath}
+	})
+	if err != nil {
+		m.logger("Warning: failed to query Immich by path for %s: %v", originalPath, err)
+		return nil
+	}
+	// Only exact matches are reliable, some versions match path prefixes
+	var matches []*immich.Asset
+	for _, a := range assets {
+		if path.Clean(a.OriginalPath) == path.Clean(originalPath) {
+			matches = append(matches, a)
+		}
+	}
+	return matches
+}
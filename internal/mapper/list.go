@@ -0,0 +1,35 @@
+package mapper
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ListedAsset is an asset with a Google URL found with Config.ListOnly.
+type ListedAsset struct {
+	URL      string `json:"url"`
+	JSONFile string `json:"json_file"`
+	Path     string `json:"path"` // empty if the media file wasn't found
+	Title    string `json:"title"`
+}
+
+// listAsset records an asset for the URL inventory of Config.ListOnly.
+func (m *Mapper) listAsset(result *Result, sc sidecar, mediaPath string) {
+	result.Listed = append(result.Listed, ListedAsset{
+		URL:      sc.md.URL,
+		JSONFile: sc.jsonPath,
+		Path:     mediaPath,
+		Title:    sc.md.Title,
+	})
+}
+
+// WriteListJSON writes the assets found with Config.ListOnly as a JSON array.
+func (r *Result) WriteListJSON(w io.Writer) error {
+	listed := r.Listed
+	if listed == nil {
+		listed = []ListedAsset{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(listed)
+}
@@ -0,0 +1,38 @@
+package mapper
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/simulot/immich-go/immich"
+)
+
+// NormalizeHash converts a SHA1 hash given as hex or base64 to the base64
+// form Immich uses for checksums.
+func NormalizeHash(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 40 {
+		if raw, err := hex.DecodeString(s); err == nil {
+			return base64.StdEncoding.EncodeToString(raw), nil
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(raw) != 20 {
+		return "", fmt.Errorf("%q is neither a hex nor a base64 SHA1 hash", s)
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// LookupHash connects to Immich and returns all assets with the given base64 SHA1 hash.
+func (m *Mapper) LookupHash(ctx context.Context, hash string) ([]*immich.Asset, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("cannot look up hashes in dry-run mode")
+	}
+	if err := m.connect(ctx); err != nil {
+		return nil, err
+	}
+	return m.searchAssetsByHash(ctx, hash)
+}
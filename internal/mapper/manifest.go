@@ -0,0 +1,80 @@
+package mapper
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+)
+
+// UploadManifest lists the orphan media files that aren't in Immich, to
+// upload them with immich-go. The files are grouped by the takeout input
+// they are in and by the Google album of their folder.
+type UploadManifest struct {
+	Archives []ManifestArchive `json:"archives"`
+}
+
+// ManifestArchive lists the missing files of one takeout input (ZIP file or
+// directory, as given on the command line). The paths are relative to it.
+type ManifestArchive struct {
+	Archive string          `json:"archive"`
+	Albums  []ManifestAlbum `json:"albums"`
+	// Files are in no album, e.g. in the "Photos from YYYY" folders
+	Files []string `json:"files"`
+}
+
+// ManifestAlbum lists the missing files in the folders of a Google album.
+type ManifestAlbum struct {
+	Album string   `json:"album"`
+	Files []string `json:"files"`
+}
+
+// missingOrphan is an orphan media file that wasn't found in Immich.
+type missingOrphan struct {
+	archive string
+	path    string
+}
+
+// uploadManifest builds the manifest of the missing orphans. It needs the
+// album titles of all inputs, as a folder's album metadata may be in
+// another archive than its media files.
+func (m *Mapper) uploadManifest() *UploadManifest {
+	manifest := &UploadManifest{Archives: make([]ManifestArchive, 0)}
+	byArchive := make(map[string]int)
+	albums := make(map[string]map[string][]string) // archive -> album -> files
+	for _, o := range m.missingOrphans {
+		i, ok := byArchive[o.archive]
+		if !ok {
+			i = len(manifest.Archives)
+			byArchive[o.archive] = i
+			manifest.Archives = append(manifest.Archives, ManifestArchive{Archive: o.archive, Albums: make([]ManifestAlbum, 0), Files: make([]string, 0)})
+			albums[o.archive] = make(map[string][]string)
+		}
+		title, ok := m.albums.titles[path.Dir(o.path)]
+		if !ok {
+			manifest.Archives[i].Files = append(manifest.Archives[i].Files, o.path)
+			continue
+		}
+		albums[o.archive][title] = append(albums[o.archive][title], o.path)
+	}
+
+	for i := range manifest.Archives {
+		a := &manifest.Archives[i]
+		titles := make([]string, 0, len(albums[a.Archive]))
+		for title := range albums[a.Archive] {
+			titles = append(titles, title)
+		}
+		sort.Strings(titles)
+		for _, title := range titles {
+			a.Albums = append(a.Albums, ManifestAlbum{Album: title, Files: albums[a.Archive][title]})
+		}
+	}
+	return manifest
+}
+
+// WriteUploadManifestJSON writes the upload manifest (see UploadManifest) as JSON.
+func (r *Result) WriteUploadManifestJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.UploadManifest)
+}
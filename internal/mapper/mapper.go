@@ -4,9 +4,6 @@ package mapper
 import (
 	"bytes"
 	"context"
-	"crypto/sha1"
-	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,22 +11,55 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/simulot/immich-go/immich"
 	"github.com/thedirtyfew/google-photos-immich-urls/internal/fshelper"
 	"github.com/thedirtyfew/google-photos-immich-urls/internal/googlephotos"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Mapping represents a single URL mapping from Google Photos to Immich.
 type Mapping struct {
-	GoogleURL   string `json:"google_url"`
-	ImmichURL   string `json:"immich_url"`
-	JSONFile    string `json:"json_file"`
-	Path        string `json:"path"`
-	Hash        string `json:"hash"`
-	MatchMethod string `json:"match_method"` // "hash" or "filename+timestamp"
+	GoogleURL   string   `json:"google_url"`
+	ImmichURL   string   `json:"immich_url"`
+	ImmichID    string   `json:"immich_id"`
+	JSONFile    string   `json:"json_file"`
+	Path        string   `json:"path"`
+	Hash        string   `json:"hash"`
+	MatchMethod string   `json:"match_method"` // The matching tier, e.g. "hash" or "filename+timestamp"
+	Title       string   `json:"title,omitempty"`
+	People      []string `json:"people,omitempty"` // Names of the people tagged in Google Photos
+	// Latitude and Longitude are the location from the sidecar (with --include-metadata)
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	// Visibility of the Immich asset ("timeline" or "archive"), if it was searched
+	Visibility string `json:"visibility,omitempty"`
+	// AlbumURLs links the asset within each Immich album it belongs to (with --link-in-album)
+	AlbumURLs []string `json:"album_urls,omitempty"`
+	// ExifDiff lists the EXIF fields that differ in Immich (with --compare-exif)
+	ExifDiff []ExifDiff `json:"exif_diff,omitempty"`
+	// Source is where the asset is in the takeout: SourceLibrary,
+	// SourceAlbumPrefix followed by the album title, or SourceSharedAlbum
+	Source string `json:"source,omitempty"`
+	// Library is the name of the shared library the asset was found in,
+	// empty for the own library
+	Library string `json:"library,omitempty"`
+	// InTrash is set if the Immich asset is in the trash (with --search-trash)
+	InTrash bool `json:"in_trash,omitempty"`
+	// Edited is set for an edited copy like "IMG_1234-edited.jpg", which
+	// shares the Google URL with the original
+	Edited bool `json:"edited,omitempty"`
+	// MotionPath is the video part of a motion photo (live photo), which
+	// shares the Google URL and Immich asset with the still
+	MotionPath string `json:"motion_path,omitempty"`
 }
 
 // NotFound represents a Google Photos asset that could not be matched in Immich.
@@ -38,6 +68,21 @@ type NotFound struct {
 	JSONFile  string `json:"json_file"`
 	Path      string `json:"path"`
 	Hash      string `json:"hash"`
+	Reason    string `json:"reason,omitempty"` // ReasonHashError if the file couldn't be hashed
+}
+
+// ReasonHashError is the NotFound.Reason of media files that couldn't be
+// hashed (with Config.HashErrorsInNotFound).
+const ReasonHashError = "hash-error"
+
+// Ambiguous represents a Google Photos asset whose hash matched several Immich assets
+// (only collected with --fail-on-multiple).
+type Ambiguous struct {
+	GoogleURL     string   `json:"google_url"`
+	JSONFile      string   `json:"json_file"`
+	Path          string   `json:"path"`
+	Hash          string   `json:"hash"`
+	CandidateURLs []string `json:"candidate_urls"`
 }
 
 // OrphanMedia represents a media file without a JSON sidecar (no Google URL available).
@@ -45,7 +90,33 @@ type OrphanMedia struct {
 	Path           string `json:"path"`
 	Hash           string `json:"hash,omitempty"`
 	ImmichURL      string `json:"immich_url,omitempty"`      // Set if found in Immich
+	ImmichID       string `json:"immich_id,omitempty"`       // Set if found in Immich
 	ImmichFilename string `json:"immich_filename,omitempty"` // Filename in Immich (to detect renames)
+	InTrash        bool   `json:"in_trash,omitempty"`        // Set if found in the Immich trash
+}
+
+// SkippedLarge represents a media file larger than --max-file-size, which was
+// neither hashed nor searched in Immich.
+type SkippedLarge struct {
+	GoogleURL string `json:"google_url,omitempty"` // Empty for orphan media
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+}
+
+// DryRunArchive lists the assets of one takeout input (ZIP file or directory)
+// found with --dry-run-with-archives.
+type DryRunArchive struct {
+	Archive     string        `json:"archive"`
+	GoogleURLs  int           `json:"google_urls"`
+	NoMediaFile int           `json:"no_media_file"`
+	Media       []DryRunMedia `json:"media"`
+}
+
+// DryRunMedia is an asset whose JSON sidecar was resolved to a media file.
+type DryRunMedia struct {
+	GoogleURL string `json:"google_url"`
+	JSONFile  string `json:"json_file"`
+	Path      string `json:"path"`
 }
 
 // Stats contains statistics about the mapping process.
@@ -54,11 +125,26 @@ type Stats struct {
 	TotalGoogleURLs   int `json:"total_google_urls"`
 	Matched           int `json:"matched"`
 	MatchedByHash     int `json:"matched_by_hash"`
-	MatchedByFilename int `json:"matched_by_filename"`
-	NotFoundInImmich  int `json:"not_found_in_immich"`
-	NoMediaFile       int `json:"no_media_file"`
-	HashErrors        int `json:"hash_errors"`
-	OrphanMedia       int `json:"orphan_media"`
+	MatchedByFilename int `json:"matched_by_filename"` // all filename-based tiers
+	// MatchedByMethod counts the matches per match_method (tier)
+	MatchedByMethod  map[string]int `json:"matched_by_method,omitempty"`
+	NotFoundInImmich int            `json:"not_found_in_immich"`
+	NoMediaFile      int            `json:"no_media_file"`
+	EditedMedia      int            `json:"edited_media"`  // edited copies mapped along with their original
+	MotionPhotos     int            `json:"motion_photos"` // stills paired with the video part of a motion photo
+	HashErrors       int            `json:"hash_errors"`
+	OrphanMedia      int            `json:"orphan_media"`
+	ChecksumMismatch int            `json:"checksum_mismatch"`
+	SkippedExcluded  int            `json:"skipped_excluded"`
+	SkippedMediaType int            `json:"skipped_media_type"` // filtered out by --media-type
+	SkippedLarge     int            `json:"skipped_large"`      // larger than --max-file-size
+	ExifMismatch     int            `json:"exif_mismatch"`      // with --compare-exif
+	InTrash          int            `json:"in_trash"`           // matched in the Immich trash, with --search-trash
+	StaleMappings    int            `json:"stale_mappings"`     // assets gone since the search, with --verify-urls
+	Ambiguous        int            `json:"ambiguous"`
+
+	// SkippedByDateFilter counts the assets taken outside of --from and --to
+	SkippedByDateFilter int `json:"skipped_by_date_filter"`
 }
 
 // Result contains the complete mapping result.
@@ -66,7 +152,35 @@ type Result struct {
 	Mappings    []Mapping     `json:"mappings"`
 	NotFound    []NotFound    `json:"not_found"`
 	OrphanMedia []OrphanMedia `json:"orphan_media"`
-	Stats       Stats         `json:"stats"`
+	Ambiguous   []Ambiguous   `json:"ambiguous,omitempty"`
+	// SkippedLarge lists the media files larger than --max-file-size
+	SkippedLarge []SkippedLarge `json:"skipped_large,omitempty"`
+	// BrowserMismatches lists the folders whose archive_browser.html count differs
+	BrowserMismatches []BrowserMismatch `json:"browser_mismatches,omitempty"`
+	// StaleMappings lists the mappings to Immich assets that don't exist anymore (with Config.VerifyURLs)
+	StaleMappings []Mapping `json:"stale_mappings,omitempty"`
+	// SuspiciousMatches lists the Immich assets matched from different hashes
+	SuspiciousMatches []SuspiciousMatch `json:"suspicious_matches,omitempty"`
+	// Albums maps the Google albums to the Immich albums of the same title (with Config.MapAlbums)
+	Albums []AlbumMapping `json:"albums,omitempty"`
+	// NotFoundAlbums lists the Google albums without an Immich album of the same title
+	NotFoundAlbums []string `json:"not_found_albums,omitempty"`
+	// Listed is the URL inventory of Config.ListOnly
+	Listed []ListedAsset `json:"-"`
+	// Archives lists the resolved media files per input with --dry-run-with-archives
+	Archives []DryRunArchive `json:"archives,omitempty"`
+	Stats    Stats           `json:"stats"`
+	// PerArchive breaks the stats down by input, keyed by archive name
+	PerArchive map[string]*ArchiveStats `json:"per_archive,omitempty"`
+	// StatsByType breaks the matches down by media type (MediaTypePhoto, MediaTypeVideo)
+	StatsByType map[string]*TypeStats `json:"stats_by_type,omitempty"`
+
+	// AlbumPlan maps each Google album name to the IDs of its matched Immich assets.
+	AlbumPlan map[string][]string `json:"-"`
+	// Cleanup reports which Google albums were migrated completely.
+	Cleanup *CleanupReport `json:"-"`
+	// UploadManifest lists the orphan media missing in Immich (with Config.UploadManifest).
+	UploadManifest *UploadManifest `json:"-"`
 }
 
 // Mapper handles the URL mapping process.
@@ -76,30 +190,396 @@ type Mapper struct {
 	serverURL        string
 	apiKey           string
 	dryRun           bool
+	dryRunArchives   bool
+	listOnly         bool
 	fallbackFilename bool
-	fsyss            []fs.FS
-	logger           func(format string, args ...interface{})
+	timezone         *time.Location
+	linkInAlbum      bool
+	mapAlbums        bool
+	normalizeURLs    bool
+	albumCache       map[string][]immichAlbum     // asset ID -> albums
+	digests          map[string]map[string]string // SHA1 -> further checksums by field, output hash by algorithm
+	hashAlgo         string
+	compat           searchCompat
+	// filenameTransform rewrites takeout filenames before the filename fallback search
+	filenameTransform   *regexp.Regexp
+	filenameReplacement string
+	filenameVariants    []string
+	filenamePrefix      string
+	albums              *albumPlan
+	deterministic       bool
+	noArchiveSearch     bool
+	searchTrash         bool
+	trashedIDs          map[string]bool // assets found in the trash by the bulk check
+	archivedIDs         map[string]bool // archived assets, for the visibility of bulk check matches
+	verifyChecksums     bool
+	excludeHashes       map[string]bool
+	knownMap            map[string]string // hash -> Immich asset ID
+	libraryRoot         string
+	takenTimeWindow     time.Duration
+	timestampTolerance  time.Duration
+	timestampFallback   bool
+	includeMetadata     bool
+	verifyURLs          bool
+	from                time.Time
+	to                  time.Time
+	searchPageSize      int
+	maxRetries          int
+	retryDelay          time.Duration
+	searchMaxPages      int
+	sniffContent        bool
+	mediaType           string
+	compareExif         bool
+	skipOrphans         bool
+	sharedLibraries     []SharedLibrary
+	preferVisibility    string
+	maxFileSize         int64
+	hashBufferSize      int
+	hashBuffers         *sync.Pool
+	statsMu             *sync.Mutex // guards Result.Stats and Result.StatsByType
+	digestsMu           *sync.Mutex // guards digests
+	hashCache           *hashCache  // nil without --cache-file
+	checkpoint          *checkpoint // nil without --checkpoint
+	progress            *progressTracker
+	concurrency         int
+	queryWorkers        int
+	hashErrorsNotFound  bool
+	failOnMultiple      bool
+	tiers               []string
+	verifyBrowser       bool
+	browserListed       map[string]int // folder -> item count in archive_browser.html
+	browserProcessed    map[string]int // folder -> media files found
+	onMapping           func(Mapping) error
+	onMappingErr        error // first error returned by onMapping
+	onNotFound          func(NotFound) error
+	onNotFoundErr       error                    // first error returned by onNotFound
+	matched             map[string]*matchedAsset // Immich asset ID -> hashes matched to it
+	matchedIDs          []string                 // keys of matched in the order they were added
+	input               string                   // path of the takeout input being processed
+	inputStats          Stats                    // Result.Stats before the input being processed
+	trackMissing        bool
+	missingOrphans      []missingOrphan
+	httpConnections     int
+	inputs              []fshelper.Input
+	maxOpenArchives     int
+	logger              func(format string, args ...interface{})
 }
 
 // Config contains mapper configuration.
 type Config struct {
-	Server           string
-	APIKey           string
-	SkipSSL          bool
-	DryRun           bool
+	Server  string
+	APIKey  string
+	SkipSSL bool
+	DryRun  bool
+	// DryRunArchives lists the resolved media files per input in dry-run.
+	DryRunArchives bool
+	// ListOnly only lists the assets with a Google URL in Result.Listed, in
+	// dry-run, without hashing or matching them.
+	ListOnly         bool
 	FallbackFilename bool
-	TakeoutPaths     []string
-	Logger           func(format string, args ...interface{})
+	// DefaultHTTPS assumes https:// for a server URL without scheme,
+	// instead of failing.
+	DefaultHTTPS bool
+	// NormalizeURLs collapses repeated slashes in the path of the server URL
+	// and logs every produced Immich URL that doesn't parse cleanly.
+	NormalizeURLs bool
+	// HTTPConnections is the number of connections kept open to the server,
+	// opened before processing starts. Defaults to QueryWorkers, at least 4.
+	HTTPConnections int
+	// HTTP tunes the timeouts and connection limits of the API client.
+	HTTP HTTPOptions
+	// Timezone overrides the zone used to interpret Immich's localDateTime
+	// when comparing timestamps. If nil, the asset's EXIF timezone is used,
+	// falling back to the local timezone.
+	Timezone *time.Location
+	// LinkInAlbum links assets that belong to exactly one Immich album
+	// within that album (/albums/<albumId>/photos/<assetId>).
+	LinkInAlbum bool
+	// MapAlbums resolves the Immich album of each Google album by title
+	// (Result.Albums, Result.NotFoundAlbums).
+	MapAlbums bool
+	// FilenameTransform, if set, is applied with FilenameReplacement
+	// (regexp.ReplaceAllString syntax) to the takeout filename before the
+	// filename fallback search.
+	FilenameTransform   *regexp.Regexp
+	FilenameReplacement string
+	// FilenameVariants are the variants of the filename searched by the
+	// filename tiers, in order (FilenameVariantBase etc.). If nil,
+	// DefaultFilenameVariants is used.
+	FilenameVariants []string
+	// FilenamePrefix is stripped from or added to filenames by
+	// FilenameVariantPrefix.
+	FilenamePrefix string
+	// Deterministic processes the takeout paths and orphan media in sorted
+	// order, so the output is identical across runs given the same inputs.
+	// It overrides Concurrency and QueryWorkers with 1.
+	Deterministic bool
+	// NoArchiveSearch only searches the timeline, skipping the second query
+	// for archived assets.
+	NoArchiveSearch bool
+	// SearchTrash also searches Immich's trash for assets that aren't found
+	// otherwise. Mappings to trashed assets are marked with in_trash.
+	SearchTrash bool
+	// SearchPageSize is the number of assets per page of a metadata search,
+	// and SearchMaxPages the number of pages read at most, so a common
+	// filename doesn't page through the whole library. Default to
+	// DefaultSearchPageSize and DefaultSearchMaxPages.
+	SearchPageSize int
+	SearchMaxPages int
+	// PreferVisibility is the visibility searched first (VisibilityTimeline
+	// or VisibilityArchive), which wins if a hash matches in both.
+	// Empty means the timeline.
+	PreferVisibility string
+	// VerifyChecksums fetches the checksum Immich reports for every hash
+	// match and warns if it differs from the searched hash.
+	VerifyChecksums bool
+	// CompareExif compares the orientation, camera and capture time in the
+	// EXIF data of matched JPEG and TIFF files with the Immich asset.
+	CompareExif bool
+	// SkipOrphans skips the detection of media files without sidecar,
+	// so they are neither hashed nor checked against Immich.
+	SkipOrphans bool
+	// SharedLibraries are searched by hash for assets of Google shared
+	// albums that aren't found in the own library.
+	SharedLibraries []SharedLibrary
+	// MaxFileSize skips media files larger than this many bytes (the
+	// uncompressed size in ZIP files) before hashing them. 0 means no limit.
+	MaxFileSize int64
+	// HashBufferSize is the size of the read buffer for hashing in bytes.
+	// Defaults to DefaultHashBufferSize.
+	HashBufferSize int
+	// HashAlgo is the algorithm of the hash in the output (HashAlgoSHA1 etc.).
+	// Immich is always searched by SHA1. Defaults to HashAlgoSHA1.
+	HashAlgo string
+	// CacheFile is a JSON file that keeps the hashes of media files between
+	// runs. It is read by New and written by Close; a cached hash is used
+	// as long as the file size is unchanged.
+	CacheFile string
+	// CheckpointFile is a JSON file that records the progress of the run,
+	// so an interrupted run is resumed from it with the same takeout paths.
+	// It is written while the inputs are processed and by Close, and
+	// removed by RemoveCheckpoint. It has no effect in dry-run; the mappings
+	// passed to OnMapping are not recorded in it.
+	CheckpointFile string
+	// HashErrorsInNotFound records media files that couldn't be hashed in
+	// Result.NotFound with ReasonHashError, instead of only counting them.
+	HashErrorsInNotFound bool
+	// ExcludeHashes lists hashes of media files to skip before querying
+	// Immich, e.g. ones already mapped in a previous run.
+	ExcludeHashes map[string]bool
+	// KnownMap maps hashes to known Immich asset IDs. Media files with a
+	// known hash are mapped directly, without querying Immich.
+	KnownMap map[string]string
+	// LibraryRoot is the path of the takeout input in an Immich external
+	// library. Media files are then searched by their originalPath first,
+	// and only hashed if they aren't found.
+	LibraryRoot string
+	// UploadManifest collects the orphan media files that aren't in Immich
+	// in Result.UploadManifest, grouped by input and album.
+	UploadManifest bool
+	// SniffContent checks the content of files without extension, so they are
+	// only matched, or reported as orphans, if they are photos or videos.
+	SniffContent bool
+	// MediaType limits processing to photos or videos (MediaTypePhoto,
+	// MediaTypeVideo), based on the file extension. Empty means all.
+	MediaType string
+	// MergeSplits opens the archive parts of a split takeout export (e.g.
+	// takeout-001.zip, takeout-002.zip) as one input, so sidecars and media
+	// files in different parts are matched (see fshelper.MergeSplits).
+	MergeSplits bool
+	// InputFormat forces how the takeout paths are opened
+	// (fshelper.FormatZip, fshelper.FormatTarGz or fshelper.FormatDir); empty
+	// detects by extension.
+	InputFormat string
+	// FailOnMultiple reports assets whose hash matches several Immich assets
+	// as ambiguous instead of mapping them to the first match.
+	FailOnMultiple bool
+	// VerifyAgainstBrowser cross-checks the media files found per folder with
+	// the counts listed in the takeout's archive_browser.html.
+	VerifyAgainstBrowser bool
+	// MatchTiers lists the matching tiers to try, in order (see TierHash etc.).
+	// If empty, DefaultMatchTiers(FallbackFilename) is used.
+	MatchTiers []string
+	// TakenTimeWindow is the tolerance of TierTakenTime on each side of the
+	// Google timestamp. Defaults to DefaultTakenTimeWindow.
+	TakenTimeWindow time.Duration
+	// VerifyURLs fetches the Immich asset of every mapping once more after
+	// the run and moves the mappings of assets that don't exist anymore to
+	// Result.StaleMappings. It needs all mappings, so it has no effect on
+	// those passed to OnMapping.
+	VerifyURLs bool
+	// IncludeMetadata adds the location from the sidecars to the mappings.
+	IncludeMetadata bool
+	// TimestampTolerance is how far the Immich timestamp of an asset may be
+	// off the Google timestamp when the filename tiers narrow down multiple
+	// matches. Defaults to DefaultTimestampTolerance.
+	TimestampTolerance time.Duration
+	// TimestampFallback keeps all matches of a filename tier if none is
+	// within TimestampTolerance, instead of matching nothing.
+	TimestampFallback bool
+	// From and To, if not zero, limit the processed assets to those taken
+	// in the range (both inclusive). Assets outside of it are skipped before
+	// they are hashed, and their media files don't count as orphans.
+	From time.Time
+	To   time.Time
+	// MaxOpenArchives is the number of takeout archives open at the same
+	// time. Archives are opened when they are processed and closed right
+	// after; with more than 1, the next ones are opened in the background.
+	// Defaults to 1.
+	MaxOpenArchives int
+	// Concurrency is the number of media files hashed at the same time.
+	// The results are still recorded in takeout order. Defaults to
+	// runtime.NumCPU().
+	Concurrency int
+	// QueryWorkers is the number of media files searched in Immich at the
+	// same time, once the media files of an input are hashed. Defaults to
+	// Concurrency.
+	QueryWorkers int
+	// OnMapping, if set, is called with every mapping as soon as it is
+	// produced. The mappings are then not collected in Result.Mappings.
+	// If it returns an error, the run is aborted with that error.
+	OnMapping func(Mapping) error
+	// OnNotFound, if set, is called with every asset that wasn't found in
+	// Immich as soon as this is determined, e.g. to queue it for another
+	// upload. The assets are still collected in Result.NotFound. Like
+	// OnMapping, it is called from the goroutine running Run, one call at a
+	// time. If it returns an error, the run is aborted with that error.
+	OnNotFound func(NotFound) error
+	// Progress, if set, is called with the number of steps done and the
+	// total, which Run counts first by listing all inputs. Every media file
+	// takes two steps, hashing and matching it. Like OnNotFound, it is
+	// called from the goroutine running Run.
+	Progress     func(done, total int)
+	TakeoutPaths []string
+	Logger       func(format string, args ...interface{})
+	// Trace, if set, is called with every request to the Immich API and its
+	// response, including headers and bodies. The API key is redacted.
+	Trace func(format string, args ...interface{})
 }
 
 // New creates a new Mapper instance.
 func New(cfg Config) (*Mapper, error) {
 	m := &Mapper{
-		serverURL:        strings.TrimSuffix(cfg.Server, "/"),
-		apiKey:           cfg.APIKey,
-		dryRun:           cfg.DryRun,
-		fallbackFilename: cfg.FallbackFilename,
-		logger:           cfg.Logger,
+		apiKey:              cfg.APIKey,
+		dryRun:              cfg.DryRun,
+		dryRunArchives:      cfg.DryRun && cfg.DryRunArchives,
+		listOnly:            cfg.DryRun && cfg.ListOnly,
+		fallbackFilename:    cfg.FallbackFilename,
+		timezone:            cfg.Timezone,
+		linkInAlbum:         cfg.LinkInAlbum,
+		mapAlbums:           cfg.MapAlbums,
+		normalizeURLs:       cfg.NormalizeURLs,
+		filenameTransform:   cfg.FilenameTransform,
+		filenameReplacement: cfg.FilenameReplacement,
+		filenameVariants:    cfg.FilenameVariants,
+		filenamePrefix:      cfg.FilenamePrefix,
+		albumCache:          make(map[string][]immichAlbum),
+		digests:             make(map[string]map[string]string),
+		albums:              newAlbumPlan(),
+		deterministic:       cfg.Deterministic,
+		noArchiveSearch:     cfg.NoArchiveSearch,
+		searchTrash:         cfg.SearchTrash,
+		trashedIDs:          make(map[string]bool),
+		archivedIDs:         make(map[string]bool),
+		preferVisibility:    cfg.PreferVisibility,
+		maxFileSize:         cfg.MaxFileSize,
+		hashBufferSize:      cfg.HashBufferSize,
+		hashAlgo:            cfg.HashAlgo,
+		hashErrorsNotFound:  cfg.HashErrorsInNotFound,
+		verifyChecksums:     cfg.VerifyChecksums,
+		excludeHashes:       cfg.ExcludeHashes,
+		knownMap:            cfg.KnownMap,
+		libraryRoot:         cfg.LibraryRoot,
+		takenTimeWindow:     cfg.TakenTimeWindow,
+		timestampTolerance:  cfg.TimestampTolerance,
+		timestampFallback:   cfg.TimestampFallback,
+		includeMetadata:     cfg.IncludeMetadata,
+		verifyURLs:          cfg.VerifyURLs,
+		from:                cfg.From,
+		to:                  cfg.To,
+		searchPageSize:      cfg.SearchPageSize,
+		maxRetries:          cfg.HTTP.MaxRetries,
+		retryDelay:          cfg.HTTP.RetryDelay,
+		searchMaxPages:      cfg.SearchMaxPages,
+		trackMissing:        cfg.UploadManifest,
+		sniffContent:        cfg.SniffContent,
+		mediaType:           cfg.MediaType,
+		compareExif:         cfg.CompareExif,
+		skipOrphans:         cfg.SkipOrphans,
+		sharedLibraries:     cfg.SharedLibraries,
+		failOnMultiple:      cfg.FailOnMultiple,
+		tiers:               cfg.MatchTiers,
+		verifyBrowser:       cfg.VerifyAgainstBrowser,
+		browserProcessed:    make(map[string]int),
+		onMapping:           cfg.OnMapping,
+		onNotFound:          cfg.OnNotFound,
+		compat:              searchCompat{visibilityField: true}, // assume a current server until detected
+		httpConnections:     cfg.HTTPConnections,
+		maxOpenArchives:     cfg.MaxOpenArchives,
+		concurrency:         cfg.Concurrency,
+		queryWorkers:        cfg.QueryWorkers,
+		logger:              cfg.Logger,
+	}
+
+	if m.filenameVariants == nil {
+		m.filenameVariants = DefaultFilenameVariants
+	}
+	if m.retryDelay <= 0 {
+		m.retryDelay = DefaultRetryDelay
+	}
+	if m.searchPageSize <= 0 {
+		m.searchPageSize = DefaultSearchPageSize
+	}
+	if m.searchMaxPages <= 0 {
+		m.searchMaxPages = DefaultSearchMaxPages
+	}
+	if m.takenTimeWindow <= 0 {
+		m.takenTimeWindow = DefaultTakenTimeWindow
+	}
+	if m.timestampTolerance <= 0 {
+		m.timestampTolerance = DefaultTimestampTolerance
+	}
+	if m.hashBufferSize <= 0 {
+		m.hashBufferSize = DefaultHashBufferSize
+	}
+	if m.hashAlgo == "" {
+		m.hashAlgo = HashAlgoSHA1
+	}
+	m.hashBuffers = &sync.Pool{}
+	m.statsMu = &sync.Mutex{}
+	m.digestsMu = &sync.Mutex{}
+	if m.concurrency <= 0 {
+		m.concurrency = runtime.NumCPU()
+	}
+	if m.queryWorkers <= 0 {
+		m.queryWorkers = m.concurrency
+	}
+	// One media file at a time, so even the log and the requests to the
+	// server are in the same order in every run
+	if cfg.Deterministic {
+		m.concurrency = 1
+		m.queryWorkers = 1
+	}
+	// Every query worker keeps its connection open between searches
+	if m.httpConnections <= 0 {
+		m.httpConnections = max(defaultHTTPConnections, m.queryWorkers)
+	}
+	if cfg.Progress != nil {
+		m.progress = &progressTracker{report: cfg.Progress}
+	}
+	if cfg.CacheFile != "" {
+		var err error
+		m.hashCache, err = loadHashCache(cfg.CacheFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m.maxOpenArchives <= 0 {
+		m.maxOpenArchives = 1
+	}
+
+	if len(m.tiers) == 0 {
+		m.tiers = DefaultMatchTiers(cfg.FallbackFilename)
 	}
 
 	if m.logger == nil {
@@ -108,21 +588,51 @@ func New(cfg Config) (*Mapper, error) {
 		}
 	}
 
-	// Parse takeout paths (handles ZIP files and wildcards)
+	if cfg.Deterministic && cfg.Concurrency > 1 {
+		m.logger("Warning: --deterministic overrides --concurrency %d, hashing one media file at a time", cfg.Concurrency)
+	}
+	if cfg.Deterministic && cfg.QueryWorkers > 1 {
+		m.logger("Warning: --deterministic overrides --query-workers %d, searching one media file at a time", cfg.QueryWorkers)
+	}
+
+	if !cfg.DryRun {
+		var err error
+		m.serverURL, err = m.normalizeServerURL(cfg.Server, cfg.DefaultHTTPS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Resolve takeout paths (handles ZIP files and wildcards); they are opened by Run.
+	// Diagnostic commands like lookup-hash don't need any.
+	takeoutPaths := cfg.TakeoutPaths
+	if cfg.Deterministic {
+		takeoutPaths = append([]string(nil), takeoutPaths...)
+		sort.Strings(takeoutPaths)
+	}
 	var err error
-	m.fsyss, err = fshelper.ParsePaths(cfg.TakeoutPaths)
+	m.inputs, err = fshelper.ResolvePaths(takeoutPaths, cfg.InputFormat)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse takeout paths: %w", err)
 	}
-
-	if len(m.fsyss) == 0 {
-		return nil, fmt.Errorf("no valid takeout files found")
+	if cfg.MergeSplits {
+		m.inputs = fshelper.MergeSplits(m.inputs)
+	}
+	if cfg.CheckpointFile != "" && !cfg.DryRun {
+		paths := make([]string, len(m.inputs))
+		for i, input := range m.inputs {
+			paths[i] = input.Path
+		}
+		m.checkpoint, err = loadCheckpoint(cfg.CheckpointFile, paths)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Create Immich client (unless dry-run)
 	if !cfg.DryRun {
 		m.client, err = immich.NewImmichClient(
-			cfg.Server,
+			m.serverURL,
 			cfg.APIKey,
 			immich.OptionVerifySSL(cfg.SkipSSL),
 		)
@@ -131,20 +641,26 @@ func New(cfg Config) (*Mapper, error) {
 		}
 
 		// Create HTTP client for direct API calls
-		m.httpClient = &http.Client{
-			Timeout: 30 * time.Second,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.SkipSSL},
-			},
+		m.httpClient = newHTTPClient(cfg.SkipSSL, m.httpConnections, cfg.HTTP)
+		if cfg.Trace != nil {
+			m.httpClient.Transport = &tracingTransport{next: m.httpClient.Transport, trace: cfg.Trace}
 		}
 	}
 
 	return m, nil
 }
 
-// Close releases resources.
+// Close releases resources and writes the hash cache and the checkpoint,
+// e.g. after Run was interrupted. The takeout archives are already closed
+// by Run.
 func (m *Mapper) Close() error {
-	return fshelper.CloseFSs(m.fsyss)
+	err := m.saveCheckpoint()
+	if m.hashCache != nil {
+		if cacheErr := m.hashCache.save(); err == nil {
+			err = cacheErr
+		}
+	}
+	return err
 }
 
 // Run executes the mapping process.
@@ -155,28 +671,76 @@ func (m *Mapper) Run(ctx context.Context) (*Result, error) {
 		OrphanMedia: make([]OrphanMedia, 0),
 	}
 
+	if len(m.inputs) == 0 {
+		return nil, fmt.Errorf("no valid takeout files found")
+	}
+	if m.checkpoint != nil {
+		m.resumeCheckpoint(result)
+	}
+
 	// Validate Immich connection (unless dry-run)
 	if !m.dryRun && m.client != nil {
-		if err := m.client.PingServer(ctx); err != nil {
-			return nil, fmt.Errorf("failed to connect to Immich server: %w", err)
+		if err := m.connect(ctx); err != nil {
+			return nil, err
 		}
-		user, err := m.client.ValidateConnection(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to validate Immich connection: %w", err)
+	}
+
+	if m.progress != nil {
+		if err := m.countProgress(ctx); err != nil {
+			return nil, err
 		}
-		m.logger("Connected to Immich as: %s", user.Email)
 	}
 
-	// Process each filesystem (ZIP file or directory)
-	for _, fsys := range m.fsyss {
-		if err := m.processFS(ctx, fsys, result); err != nil {
+	if err := m.processInputs(ctx, result); err != nil {
+		return nil, err
+	}
+
+	if m.verifyURLs && !m.dryRun {
+		if err := m.verifyMappings(ctx, result); err != nil {
 			return nil, err
 		}
 	}
+	if m.verifyBrowser {
+		m.compareArchiveBrowser(result)
+	}
+	m.findSuspiciousMatches(result)
+
+	result.AlbumPlan = m.albums.build()
+	result.Cleanup = m.albums.cleanup()
+	if m.mapAlbums && !m.dryRun {
+		if err := m.mapGoogleAlbums(ctx, result); err != nil {
+			m.logger("Warning: %v", err)
+		}
+	}
+	if m.trackMissing {
+		result.UploadManifest = m.uploadManifest()
+	}
 
 	return result, nil
 }
 
+// connect validates the Immich connection and detects the server version.
+func (m *Mapper) connect(ctx context.Context) error {
+	if err := m.client.PingServer(ctx); err != nil {
+		return fmt.Errorf("failed to connect to Immich server: %w", err)
+	}
+	user, err := m.client.ValidateConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to validate Immich connection: %w", err)
+	}
+	m.logger("Connected to Immich as: %s", user.Email)
+
+	version, err := m.detectServerVersion(ctx)
+	if err != nil {
+		m.logger("Warning: failed to detect Immich server version, assuming a current server: %v", err)
+	} else {
+		m.logger("Immich server version: %s", version)
+	}
+
+	m.warmUp(ctx)
+	return nil
+}
+
 // mediaExtensions lists file extensions considered as media files.
 var mediaExtensions = map[string]bool{
 	".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
@@ -190,12 +754,310 @@ func isMediaFile(filename string) bool {
 	return mediaExtensions[ext]
 }
 
-// processFS walks a single filesystem and processes JSON files.
+// Media types for Config.MediaType.
+const (
+	MediaTypeAll   = "all"
+	MediaTypePhoto = "photo"
+	MediaTypeVideo = "video"
+)
+
+// videoExtensions lists the media extensions of videos; all others are photos.
+var videoExtensions = map[string]bool{
+	".mp4": true, ".mov": true, ".avi": true, ".mkv": true, ".3gp": true, ".webm": true,
+}
+
+// Visibilities for Config.PreferVisibility.
+const (
+	VisibilityTimeline = "timeline"
+	VisibilityArchive  = "archive"
+)
+
+// skipDate returns true if an asset was taken outside of --from and --to.
+func (m *Mapper) skipDate(md *googlephotos.GoogleMetaData) bool {
+	if m.from.IsZero() && m.to.IsZero() {
+		return false
+	}
+	taken := md.PhotoTakenTime.Time()
+	return (!m.from.IsZero() && taken.Before(m.from)) || (!m.to.IsZero() && taken.After(m.to))
+}
+
+// skipMediaType returns true if the file is filtered out by --media-type.
+// Files without extension are never filtered out.
+func (m *Mapper) skipMediaType(filename string) bool {
+	if m.mediaType == "" || m.mediaType == MediaTypeAll {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(filename))
+	if ext == "" {
+		return false
+	}
+	if videoExtensions[ext] {
+		return m.mediaType != MediaTypeVideo
+	}
+	return m.mediaType != MediaTypePhoto
+}
+
+// mediaTypeOf returns MediaTypeVideo or MediaTypePhoto for a media file,
+// by its extension. Files without a known video extension are photos.
+func mediaTypeOf(filename string) string {
+	if videoExtensions[strings.ToLower(path.Ext(filename))] {
+		return MediaTypeVideo
+	}
+	return MediaTypePhoto
+}
+
+// ArchiveStats are the matching statistics of one takeout input.
+type ArchiveStats struct {
+	GoogleURLs  int `json:"google_urls"`
+	Matched     int `json:"matched"`
+	NotFound    int `json:"not_found"`
+	NoMediaFile int `json:"no_media_file"`
+	OrphanMedia int `json:"orphan_media"`
+}
+
+// TypeStats are the matching statistics of one media type.
+type TypeStats struct {
+	Total     int     `json:"total"` // assets with a Google URL and media file
+	Matched   int     `json:"matched"`
+	NotFound  int     `json:"not_found"`  // not found in Immich or ambiguous
+	MatchRate float64 `json:"match_rate"` // matched / total, from 0 to 1
+}
+
+// candidate is an asset from a JSON sidecar whose media file has been hashed
+// and is waiting to be matched against Immich.
+type candidate struct {
+	md        *googlephotos.GoogleMetaData
+	jsonPath  string
+	mediaPath string
+	mediaFile string
+	size      int64       // -1 if unknown
+	edited    bool        // an edited copy like "IMG_1234-edited.jpg"
+	hash      string      // empty if found by path
+	exif      *exifFields // with --compare-exif, nil if not readable
+	// motionPath is the video part of a motion photo, see findMotionFile
+	motionPath string
+	// pathAssets are the assets found by --library-root, without hashing
+	pathAssets []*immich.Asset
+}
+
+// sidecar is the metadata of an asset with a Google URL, from its JSON
+// sidecar or from the album JSON of a shared album.
+type sidecar struct {
+	md       *googlephotos.GoogleMetaData
+	jsonPath string
+	jsonName string // file name the media file is resolved from
+}
+
+// orphanFile is a media file without a JSON sidecar.
+type orphanFile struct {
+	path    string
+	hash    string
+	hashErr error
+}
+
+// openedInput is the outcome of opening a takeout input.
+type openedInput struct {
+	fsys fs.FS
+	err  error
+}
+
+// processInputs processes each takeout input (ZIP file or directory) in order.
+// Inputs are opened in the background up to maxOpenArchives ahead, and each
+// one is closed as soon as it has been processed.
+func (m *Mapper) processInputs(ctx context.Context, result *Result) error {
+	opened := make([]chan openedInput, len(m.inputs))
+	open := func(i int) {
+		if i >= len(m.inputs) {
+			return
+		}
+		opened[i] = make(chan openedInput, 1)
+		go func() {
+			fsys, err := m.inputs[i].Open()
+			opened[i] <- openedInput{fsys, err}
+		}()
+	}
+	// closeFrom closes the inputs that were opened ahead, after an error
+	closeFrom := func(i int) {
+		for ; i < len(m.inputs) && opened[i] != nil; i++ {
+			if in := <-opened[i]; in.err == nil {
+				fshelper.CloseFSs([]fs.FS{in.fsys})
+			}
+		}
+	}
+
+	for i := 0; i < m.maxOpenArchives; i++ {
+		open(i)
+	}
+	for i, input := range m.inputs {
+		in := <-opened[i]
+		if in.err != nil {
+			closeFrom(i + 1)
+			return fmt.Errorf("failed to open %s: %w", input.Path, in.err)
+		}
+
+		if m.dryRunArchives {
+			result.Archives = append(result.Archives, DryRunArchive{Archive: archiveName(input, in.fsys), Media: []DryRunMedia{}})
+		}
+		m.input = input.Path
+		m.inputStats = m.snapshotStats(result)
+		m.startCheckpointInput()
+		m.startProgress(i)
+		err := m.processFS(ctx, in.fsys, result)
+		fshelper.CloseFSs([]fs.FS{in.fsys})
+		open(i + m.maxOpenArchives)
+		if err != nil {
+			closeFrom(i + 1)
+			return err
+		}
+		m.addArchiveStats(result, archiveName(input, in.fsys))
+		m.finishProgress()
+		m.finishCheckpointInput()
+	}
+	return nil
+}
+
+// addArchiveStats records the stats of an input that has been processed,
+// as the difference to the stats before it, and logs them. Inputs with the
+// same name are added up.
+func (m *Mapper) addArchiveStats(result *Result, name string) {
+	before, after := m.inputStats, m.snapshotStats(result)
+	if result.PerArchive == nil {
+		result.PerArchive = make(map[string]*ArchiveStats)
+	}
+	a := result.PerArchive[name]
+	if a == nil {
+		a = &ArchiveStats{}
+		result.PerArchive[name] = a
+	}
+	a.GoogleURLs += after.TotalGoogleURLs - before.TotalGoogleURLs
+	a.Matched += after.Matched - before.Matched
+	a.NotFound += after.NotFoundInImmich - before.NotFoundInImmich
+	a.NoMediaFile += after.NoMediaFile - before.NoMediaFile
+	a.OrphanMedia += after.OrphanMedia - before.OrphanMedia
+
+	m.logger("Finished %s: %d URLs, %d matched, %d not found, %d without media file, %d orphan media",
+		name, after.TotalGoogleURLs-before.TotalGoogleURLs, after.Matched-before.Matched,
+		after.NotFoundInImmich-before.NotFoundInImmich, after.NoMediaFile-before.NoMediaFile,
+		after.OrphanMedia-before.OrphanMedia)
+}
+
+// archiveName returns the name of an input: the ZIP file name without
+// extension, or the name of the directory.
+func archiveName(input fshelper.Input, fsys fs.FS) string {
+	if n, ok := fsys.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return filepath.Base(input.Path)
+}
+
+// currentArchive returns the entry of the input being processed with
+// --dry-run-with-archives, or nil.
+func (r *Result) currentArchive() *DryRunArchive {
+	if len(r.Archives) == 0 {
+		return nil
+	}
+	return &r.Archives[len(r.Archives)-1]
+}
+
+// processFS processes a single filesystem in phases:
+//
+//  1. collect: walk the filesystem, resolve sidecars to media files and hash them
+//  2. bulk-exist: check all hashes against Immich in a few batched requests
+//  3. resolve-existing: map assets whose hash exists in Immich
+//  4. fallback-missing: run the per-asset fallback searches for the rest
+//
+// If the bulk check is not available, each asset is searched by hash individually.
 func (m *Mapper) processFS(ctx context.Context, fsys fs.FS, result *Result) error {
+	candidates, orphans, err := m.collect(ctx, fsys, result)
+	if err != nil {
+		return err
+	}
+	m.checkpointCollected()
+
+	if m.dryRun {
+		for _, o := range orphans {
+			m.incr(&result.Stats.OrphanMedia)
+			m.logger("Orphan media: %s", o.path)
+			result.OrphanMedia = append(result.OrphanMedia, OrphanMedia{Path: o.path})
+		}
+		return nil
+	}
+
+	// Check which hashes exist in Immich (the same file may appear in several albums)
+	var hashes []string
+	seen := make(map[string]bool)
+	addHash := func(h string) {
+		if !seen[h] {
+			seen[h] = true
+			hashes = append(hashes, h)
+		}
+	}
+	for _, c := range candidates {
+		if c.hash != "" {
+			addHash(c.hash)
+		}
+	}
+	for _, o := range orphans {
+		if o.hashErr == nil {
+			addHash(o.hash)
+		}
+	}
+	// Known matches don't need to be checked
+	hashes = slices.DeleteFunc(hashes, func(h string) bool {
+		_, ok := m.knownMap[h]
+		return ok
+	})
+	// The bulk check reports only one asset per hash, so --fail-on-multiple
+	// needs the individual searches to see all candidates, and
+	// --prefer-visibility archive needs them to search the archive first.
+	var existing map[string]string
+	if !m.failOnMultiple && m.preferVisibility != VisibilityArchive && m.hasTier(TierHash) {
+		existing, err = m.bulkCheckExisting(ctx, hashes)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			m.logger("Warning: bulk existence check failed, searching each asset individually: %v", err)
+			existing = nil
+		}
+	}
+
+	// Resolve existing assets and run the fallback for missing ones, in
+	// parallel; the outcomes are recorded in takeout order
+	err = runOrdered(ctx, m.queryWorkers, len(candidates), func(ctx context.Context, i int) candidateMatch {
+		return m.findMatch(ctx, candidates[i], existing)
+	}, func(i int, match candidateMatch) error {
+		m.matchCandidate(ctx, candidates[i], match, result)
+		m.markCheckpoint(candidates[i].jsonPath, candidates[i].mediaPath)
+		m.advanceProgress()
+		if m.onMappingErr != nil {
+			return m.onMappingErr
+		}
+		return m.onNotFoundErr
+	})
+	if err != nil {
+		return err
+	}
+
+	return runOrdered(ctx, m.queryWorkers, len(orphans), func(ctx context.Context, i int) orphanMatch {
+		return m.findOrphan(ctx, orphans[i], existing)
+	}, func(i int, match orphanMatch) error {
+		m.matchOrphan(ctx, orphans[i], match, result)
+		m.markCheckpoint("", orphans[i].path)
+		m.advanceProgress()
+		return nil
+	})
+}
+
+// collect walks a filesystem, resolves each JSON sidecar with a Google URL to its
+// media file and hashes it. Media files without a sidecar are returned as orphans.
+func (m *Mapper) collect(ctx context.Context, fsys fs.FS, result *Result) ([]candidate, []orphanFile, error) {
 	// Build a map of directory -> files for matching JSON to media
 	dirFiles := make(map[string][]string)
 	// Track all media files (full paths)
 	allMediaFiles := make(map[string]bool)
+	// Collect JSON files in the same pass, so huge archives are only walked once
+	var jsonPaths []string
 
 	err := fs.WalkDir(fsys, ".", func(fpath string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -209,278 +1071,832 @@ func (m *Mapper) processFS(ctx context.Context, fsys fs.FS, result *Result) erro
 		filename := path.Base(fpath)
 		dirFiles[dir] = append(dirFiles[dir], filename)
 
-		// Track media files
-		if isMediaFile(filename) {
+		// Track media and JSON files
+		if isMediaFile(filename) && m.skipMediaType(filename) {
+			return nil
+		}
+		if isMediaFile(filename) || (m.sniffContent && path.Ext(filename) == "" && sniffMedia(fsys, fpath)) {
 			allMediaFiles[fpath] = true
+			if m.verifyBrowser {
+				m.browserProcessed[path.Base(dir)]++
+			}
+		} else if m.verifyBrowser && filename == googlephotos.ArchiveBrowserFile {
+			m.readArchiveBrowser(fsys, fpath)
+		} else if strings.HasSuffix(strings.ToLower(filename), ".json") {
+			jsonPaths = append(jsonPaths, fpath)
 		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("failed to walk filesystem: %w", err)
+		return nil, nil, fmt.Errorf("failed to walk filesystem: %w", err)
 	}
 
 	// Track which media files have been claimed by a JSON sidecar
 	claimedMedia := make(map[string]bool)
-
-	// Process JSON files
-	err = fs.WalkDir(fsys, ".", func(fpath string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	var candidates []candidate
+
+	// Read the JSON files. The assets listed in a shared album JSON are
+	// resolved after the per-asset sidecars, and skipped if a sidecar has
+	// the same URL.
+	var sidecars, albumItems []sidecar
+	sidecarURLs := make(map[string]bool)
+	for _, fpath := range jsonPaths {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
 		}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		if d.IsDir() || !strings.HasSuffix(strings.ToLower(fpath), ".json") {
-			return nil
-		}
-
-		result.Stats.TotalJSONFiles++
+		m.incr(&result.Stats.TotalJSONFiles)
 
 		// Read and parse JSON
 		data, err := fs.ReadFile(fsys, fpath)
 		if err != nil {
 			m.logger("Warning: failed to read %s: %v", fpath, err)
-			return nil
+			continue
 		}
 
 		md, err := googlephotos.ParseMetadata(data)
 		if err != nil {
 			// Not all JSON files are metadata files
-			return nil
+			continue
+		}
+
+		// Untitled albums are named after their folder, like "Untitled(1)"
+		if md.IsUntitledAlbum() {
+			md.Title = path.Base(path.Dir(fpath))
+		}
+
+		// Remember album folders for the album plan
+		if md.IsAlbum() {
+			m.albums.addAlbum(path.Dir(fpath), md.Title)
+			if md.IsSharedAlbum() {
+				m.albums.markShared(path.Dir(fpath))
+			}
+			for _, item := range md.AlbumAssets() {
+				// Resolved like a sidecar named after the asset's title
+				albumItems = append(albumItems, sidecar{md: item, jsonPath: fpath, jsonName: item.Title + ".json"})
+			}
+			continue
 		}
 
 		// Skip if not an asset or has no URL
 		if !md.IsAsset() || !md.HasURL() {
-			return nil
+			continue
+		}
+		sidecarURLs[md.URL] = true
+		sidecars = append(sidecars, sidecar{md: md, jsonPath: fpath, jsonName: path.Base(fpath)})
+	}
+	for _, item := range albumItems {
+		if !sidecarURLs[item.md.URL] {
+			sidecars = append(sidecars, item)
+		}
+	}
+
+	// The media names of the sidecars per folder, so a video with a sidecar
+	// of its own isn't taken for the video part of a motion photo
+	ownSidecars := make(map[string]map[string]bool)
+	for _, sc := range sidecars {
+		dir := path.Dir(sc.jsonPath)
+		if ownSidecars[dir] == nil {
+			ownSidecars[dir] = make(map[string]bool)
 		}
+		ownSidecars[dir][strings.ToLower(normalizeName(sidecarBaseName(sc.jsonName)))] = true
+		ownSidecars[dir][strings.ToLower(normalizeName(sc.md.Title))] = true
+	}
+
+	// Resolve the assets to their media files
+	var resolved []candidate
+	for _, sc := range sidecars {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		md, fpath := sc.md, sc.jsonPath
 
-		result.Stats.TotalGoogleURLs++
+		m.incr(&result.Stats.TotalGoogleURLs)
+		archive := result.currentArchive()
+		if archive != nil {
+			archive.GoogleURLs++
+		}
 
 		// Find the corresponding media file
 		dir := path.Dir(fpath)
-		jsonBase := path.Base(fpath)
-		mediaFile := m.findMediaFile(jsonBase, md.Title, dirFiles[dir])
+		m.albums.countAsset(dir)
+		mediaFile := m.findMediaFile(sc.jsonName, md.Title, dirFiles[dir])
+		motionFile := ""
+		if mediaFile != "" {
+			motionFile = findMotionFile(mediaFile, dirFiles[dir], ownSidecars[dir])
+		}
 
-		if mediaFile == "" {
-			result.Stats.NoMediaFile++
-			m.logger("Warning: no media file found for %s", fpath)
-			return nil
+		// Skipped by date before hashing, but claimed, so the files aren't
+		// hashed as orphans either
+		if m.skipDate(md) {
+			m.incr(&result.Stats.SkippedByDateFilter)
+			if mediaFile != "" {
+				claimedMedia[path.Join(dir, mediaFile)] = true
+				for _, edited := range findEditedFiles(mediaFile, dirFiles[dir]) {
+					claimedMedia[path.Join(dir, edited)] = true
+				}
+			}
+			if motionFile != "" {
+				claimedMedia[path.Join(dir, motionFile)] = true
+			}
+			continue
 		}
 
-		// Compute hash of media file
-		mediaPath := path.Join(dir, mediaFile)
-		claimedMedia[mediaPath] = true
+		// With --sniff-content, files without extension only count if they are media
+		if mediaFile != "" && m.sniffContent && path.Ext(mediaFile) == "" && !allMediaFiles[path.Join(dir, mediaFile)] {
+			m.logger("Warning: %s is not a photo or video", path.Join(dir, mediaFile))
+			mediaFile = ""
+		}
 
-		hash, err := m.computeHash(fsys, mediaPath)
-		if err != nil {
-			result.Stats.HashErrors++
-			m.logger("Warning: failed to compute hash for %s: %v", mediaPath, err)
-			return nil
+		if mediaFile == "" {
+			m.incr(&result.Stats.NoMediaFile)
+			if archive != nil {
+				archive.NoMediaFile++
+			}
+			if sc.jsonName != path.Base(fpath) {
+				m.logger("Warning: no media file found for %s listed in %s", md.Title, fpath)
+			} else {
+				m.logger("Warning: no media file found for %s", fpath)
+			}
+			if m.listOnly {
+				m.listAsset(result, sc, "")
+			}
+			continue
 		}
 
-		// Query Immich for matching asset
-		if m.dryRun {
-			m.logger("Dry-run: would query Immich for hash %s (file: %s, URL: %s)", hash, mediaFile, md.URL)
-			return nil
+		if m.skipMediaType(mediaFile) {
+			m.incr(&result.Stats.SkippedMediaType)
+			continue
 		}
 
-		m.logger("Processing: %s (hash: %s)", mediaPath, hash)
+		if m.listOnly {
+			m.listAsset(result, sc, path.Join(dir, mediaFile))
+			continue
+		}
 
-		// Try hash-based matching first (searches all visibility types)
-		foundAssets, err := m.searchAssetsByHash(ctx, hash)
-		if err != nil {
-			m.logger("Warning: failed to query Immich by hash for %s: %v", mediaPath, err)
+		// The video part of a motion photo belongs to the still: it is
+		// noted in the still's mapping, not hashed or reported as orphan
+		motionPath := ""
+		if motionFile != "" {
+			motionPath = path.Join(dir, motionFile)
+			claimedMedia[motionPath] = true
+			m.incr(&result.Stats.MotionPhotos)
 		}
 
-		matchedByHash := len(foundAssets) > 0
+		resolve := func(mediaFile string, edited bool) {
+			mediaPath := path.Join(dir, mediaFile)
+			claimedMedia[mediaPath] = true
 
-		// Fallback to filename-based matching if hash didn't work (opt-in)
-		if len(foundAssets) == 0 && m.fallbackFilename {
-			// Try with the original filename from metadata
-			searchName := md.Title
-			if searchName == "" {
-				searchName = mediaFile
+			size := int64(-1)
+			if info, err := fs.Stat(fsys, mediaPath); err == nil {
+				size = info.Size()
 			}
-			// Remove extension for search (Immich stores without extension sometimes)
-			baseName := strings.TrimSuffix(searchName, path.Ext(searchName))
-
-			foundAssets, err = m.searchAssetsByFilename(ctx, searchName)
-			if err != nil {
-				m.logger("Warning: failed to query Immich by filename for %s: %v", searchName, err)
+			if m.skipLarge(mediaPath, md.URL, size, result) {
+				return
 			}
-
-			// If still not found, try base name
-			if len(foundAssets) == 0 && baseName != searchName {
-				foundAssets, err = m.searchAssetsByFilename(ctx, baseName)
-				if err != nil {
-					m.logger("Warning: failed to query Immich by basename for %s: %v", baseName, err)
-				}
+			// Matched before the run was interrupted
+			if m.checkpointed(fpath, mediaPath) {
+				return
+			}
+			c := candidate{md: md, jsonPath: fpath, mediaPath: mediaPath, mediaFile: mediaFile, size: size, edited: edited}
+			if !edited {
+				c.motionPath = motionPath
 			}
+			resolved = append(resolved, c)
+		}
+		resolve(mediaFile, false)
 
-			// If multiple matches, filter by timestamp from Google metadata
-			if len(foundAssets) > 1 && md.PhotoTakenTime != nil {
-				googleTime := md.PhotoTakenTime.Time()
-				if !googleTime.IsZero() {
-					foundAssets = filterByTimestamp(foundAssets, googleTime)
-				}
+		// Edited copies share the sidecar of the original and get their own mapping
+		for _, edited := range findEditedFiles(mediaFile, dirFiles[dir]) {
+			if claimedMedia[path.Join(dir, edited)] || m.skipMediaType(edited) {
+				continue
 			}
+			m.incr(&result.Stats.EditedMedia)
+			m.albums.countAsset(dir)
+			resolve(edited, true)
 		}
+	}
+
+	// The URL inventory needs no hashes or orphans
+	if m.listOnly {
+		return nil, nil, nil
+	}
 
-		if len(foundAssets) == 0 {
-			result.Stats.NotFoundInImmich++
-			result.NotFound = append(result.NotFound, NotFound{
-				GoogleURL: md.URL,
-				JSONFile:  fpath,
-				Path:      mediaPath,
-				Hash:      hash,
-			})
-			m.logger("Not found in Immich: %s (hash: %s)", mediaPath, hash)
+	// Hash the media files in parallel and record the outcomes in order
+	err = runOrdered(ctx, m.concurrency, len(resolved), func(ctx context.Context, i int) hashedMedia {
+		return m.hashMedia(ctx, fsys, resolved[i].mediaPath)
+	}, func(i int, h hashedMedia) error {
+		m.advanceProgress()
+		c := resolved[i]
+		if h.err != nil {
+			m.incr(&result.Stats.HashErrors)
+			m.logger("Warning: failed to compute hash for %s: %v", c.mediaPath, h.err)
+			if m.hashErrorsNotFound {
+				m.addNotFound(result, NotFound{
+					GoogleURL: c.md.URL,
+					JSONFile:  c.jsonPath,
+					Path:      c.mediaPath,
+					Reason:    ReasonHashError,
+				})
+				return m.onNotFoundErr
+			}
+			return nil
+		}
+		if len(h.pathAssets) == 0 && m.excludeHashes[h.hash] {
+			m.incr(&result.Stats.SkippedExcluded)
+			m.logger("Skipping excluded hash %s (file: %s)", h.hash, c.mediaPath)
 			return nil
 		}
 
-		// Use first match
-		immichURL := fmt.Sprintf("%s/photos/%s", m.serverURL, foundAssets[0].ID)
-		var matchMethod string
-		if matchedByHash {
-			matchMethod = "hash"
-			result.Stats.MatchedByHash++
-		} else {
-			matchMethod = "filename+timestamp"
-			result.Stats.MatchedByFilename++
-			m.logger("Matched by filename (hash mismatch): %s", mediaFile)
-		}
-		result.Mappings = append(result.Mappings, Mapping{
-			GoogleURL:   md.URL,
-			ImmichURL:   immichURL,
-			JSONFile:    fpath,
-			Path:        mediaPath,
-			Hash:        hash,
-			MatchMethod: matchMethod,
-		})
-		result.Stats.Matched++
-
-		if len(foundAssets) > 1 {
-			m.logger("Warning: multiple Immich assets found for %s, using first match", mediaFile)
+		if m.dryRun {
+			m.logger("Dry-run: would query Immich for hash %s (file: %s, URL: %s)", h.hash, c.mediaFile, c.md.URL)
+			if archive := result.currentArchive(); archive != nil {
+				archive.Media = append(archive.Media, DryRunMedia{GoogleURL: c.md.URL, JSONFile: c.jsonPath, Path: c.mediaPath})
+			}
+			return nil
 		}
 
+		if h.exifErr != nil {
+			m.logger("Warning: failed to read EXIF data of %s: %v", c.mediaPath, h.exifErr)
+		}
+		c.hash, c.exif, c.pathAssets = h.hash, h.exif, h.pathAssets
+		candidates = append(candidates, c)
 		return nil
 	})
-
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	if m.skipOrphans {
+		return candidates, nil, nil
 	}
 
 	// Find orphan media files (media without JSON sidecar)
+	var orphanPaths []string
 	for mediaPath := range allMediaFiles {
-		if !claimedMedia[mediaPath] {
-			result.Stats.OrphanMedia++
-
-			orphan := OrphanMedia{Path: mediaPath}
-
-			// Try to compute hash and check Immich (unless dry-run)
-			if !m.dryRun {
-				hash, err := m.computeHash(fsys, mediaPath)
-				if err == nil {
-					orphan.Hash = hash
-					m.logger("Orphan media: %s (hash: %s)", mediaPath, hash)
-
-					// Check if it exists in Immich
-					assets, err := m.searchAssetsByHash(ctx, hash)
-					if err == nil && len(assets) > 0 {
-						asset := assets[0]
-						orphan.ImmichURL = fmt.Sprintf("%s/photos/%s", m.serverURL, asset.ID)
-						orphan.ImmichFilename = asset.OriginalFileName
-
-						// Log if filename differs (for user awareness)
-						takeoutFilename := path.Base(mediaPath)
-						if asset.OriginalFileName != takeoutFilename {
-							m.logger("Filename mismatch: takeout=%s, immich=%s", takeoutFilename, asset.OriginalFileName)
-						}
-					}
-				} else {
-					m.logger("Orphan media: %s (hash error: %v)", mediaPath, err)
-				}
-			} else {
-				m.logger("Orphan media: %s", mediaPath)
+		if !claimedMedia[mediaPath] && !m.checkpointed("", mediaPath) {
+			orphanPaths = append(orphanPaths, mediaPath)
+		}
+	}
+	if m.deterministic {
+		sort.Strings(orphanPaths)
+	}
+
+	// Orphans are only hashed to check them against Immich (not in dry-run)
+	orphans := make([]orphanFile, 0, len(orphanPaths))
+	if m.dryRun {
+		for _, mediaPath := range orphanPaths {
+			orphans = append(orphans, orphanFile{path: mediaPath})
+		}
+		return candidates, orphans, nil
+	}
+	if m.maxFileSize > 0 {
+		orphanPaths = slices.DeleteFunc(orphanPaths, func(mediaPath string) bool {
+			info, err := fs.Stat(fsys, mediaPath)
+			return err == nil && m.skipLarge(mediaPath, "", info.Size(), result)
+		})
+	}
+	err = runOrdered(ctx, m.concurrency, len(orphanPaths), func(ctx context.Context, i int) orphanFile {
+		o := orphanFile{path: orphanPaths[i]}
+		o.hash, o.hashErr = m.computeHash(ctx, fsys, o.path)
+		return o
+	}, func(i int, o orphanFile) error {
+		m.advanceProgress()
+		if o.hashErr == nil && m.excludeHashes[o.hash] {
+			m.incr(&result.Stats.SkippedExcluded)
+			m.logger("Skipping excluded hash %s (file: %s)", o.hash, o.path)
+			return nil
+		}
+		orphans = append(orphans, o)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return candidates, orphans, nil
+}
+
+// hashedMedia is the outcome of hashMedia.
+type hashedMedia struct {
+	pathAssets []*immich.Asset // found by --library-root, not hashed then
+	hash       string
+	err        error // hashing failed
+	exif       *exifFields
+	exifErr    error
+}
+
+// hashMedia looks up a media file by its library path or hashes it, and
+// reads its EXIF data if needed for matching. It only reads shared state, so
+// collect runs it for several files at once.
+func (m *Mapper) hashMedia(ctx context.Context, fsys fs.FS, mediaPath string) hashedMedia {
+	var h hashedMedia
+	// With --library-root, files found by their path in Immich aren't hashed
+	if m.libraryRoot != "" && !m.dryRun {
+		h.pathAssets = m.searchByLibraryPath(ctx, mediaPath)
+	}
+	if len(h.pathAssets) == 0 {
+		h.hash, h.err = m.computeHash(ctx, fsys, mediaPath)
+		if h.err != nil || m.excludeHashes[h.hash] {
+			return h
+		}
+	}
+	if !m.dryRun && (m.compareExif || m.hasTier(TierCameraFingerprint)) {
+		h.exif, h.exifErr = readExif(fsys, mediaPath)
+	}
+	return h
+}
+
+// skipLarge returns true if the file is larger than --max-file-size and
+// records it as skipped. googleURL is empty for orphan media.
+func (m *Mapper) skipLarge(mediaPath, googleURL string, size int64, result *Result) bool {
+	if m.maxFileSize <= 0 || size <= m.maxFileSize {
+		return false
+	}
+	m.incr(&result.Stats.SkippedLarge)
+	result.SkippedLarge = append(result.SkippedLarge, SkippedLarge{GoogleURL: googleURL, Path: mediaPath, Size: size})
+	m.logger("Skipping large file %s (%d bytes)", mediaPath, size)
+	return true
+}
+
+// candidateMatch is the outcome of findMatch.
+type candidateMatch struct {
+	assets  []*immich.Asset
+	method  string
+	owner   *Mapper // sends the requests about the match
+	library string  // shared library the match is from, if any
+}
+
+// findMatch searches Immich for a single asset. It only reads shared state,
+// so processFS runs it for several assets at once; matchCandidate records
+// the outcome. existing holds the asset IDs from the bulk existence check,
+// keyed by hash; if it is nil, the asset is searched by hash individually.
+func (m *Mapper) findMatch(ctx context.Context, c candidate, existing map[string]string) candidateMatch {
+	if len(c.pathAssets) > 0 {
+		m.logger("Processing: %s (found by path)", c.mediaPath)
+	} else {
+		m.logger("Processing: %s (hash: %s)", c.mediaPath, c.hash)
+	}
+
+	// Known matches skip the API, otherwise try the matching tiers in order
+	match := candidateMatch{owner: m}
+	if len(c.pathAssets) > 0 {
+		match.assets = c.pathAssets
+		match.method = MethodLibraryPath
+	} else if id, ok := m.knownMap[c.hash]; ok {
+		match.assets = []*immich.Asset{{ID: id}}
+		match.method = MethodKnownMap
+	} else {
+		for _, tier := range m.tiers {
+			match.assets = m.runTier(ctx, tier, c, existing)
+			if len(match.assets) > 0 {
+				match.method = tier
+				break
 			}
+		}
+	}
 
-			result.OrphanMedia = append(result.OrphanMedia, orphan)
+	// Assets of shared albums may only be in the library of another user
+	// (owner then sends the requests about the match with that user's key)
+	if len(match.assets) == 0 && m.albums.isShared(path.Dir(c.jsonPath)) && len(m.sharedLibraries) > 0 {
+		assets, other, library := m.searchSharedLibraries(ctx, c)
+		if len(assets) > 0 {
+			match = candidateMatch{assets: assets, method: TierHash, owner: other, library: library}
 		}
 	}
+	return match
+}
+
+// matchCandidate records the outcome of findMatch for a single asset in result.
+func (m *Mapper) matchCandidate(ctx context.Context, c candidate, match candidateMatch, result *Result) {
+	md := c.md
+	mediaFile := c.mediaFile
+	mediaPath := c.mediaPath
+	hash := c.hash
+	outHash := m.outputHash(hash)
+	foundAssets, matchMethod, owner, library := match.assets, match.method, match.owner, match.library
+
+	matchedByHash := matchMethod == TierHash
+	if matchedByHash && m.verifyChecksums {
+		owner.verifyChecksum(ctx, foundAssets[0], hash, mediaPath, result)
+	}
 
+	if len(foundAssets) == 0 {
+		m.incr(&result.Stats.NotFoundInImmich)
+		m.countType(result, mediaFile, false)
+		m.addNotFound(result, NotFound{
+			GoogleURL: md.URL,
+			JSONFile:  c.jsonPath,
+			Path:      mediaPath,
+			Hash:      outHash,
+		})
+		m.logger("Not found in Immich: %s (hash: %s)", mediaPath, outHash)
+		return
+	}
+
+	// With --fail-on-multiple, several hash matches need a human decision
+	if matchedByHash && len(foundAssets) > 1 && m.failOnMultiple {
+		ambiguous := Ambiguous{
+			GoogleURL: md.URL,
+			JSONFile:  c.jsonPath,
+			Path:      mediaPath,
+			Hash:      outHash,
+		}
+		for _, a := range foundAssets {
+			ambiguous.CandidateURLs = append(ambiguous.CandidateURLs, owner.assetURL(ctx, a.ID))
+		}
+		m.incr(&result.Stats.Ambiguous)
+		m.countType(result, mediaFile, false)
+		result.Ambiguous = append(result.Ambiguous, ambiguous)
+		m.logger("Ambiguous: %d Immich assets found for %s (hash: %s)", len(foundAssets), mediaPath, outHash)
+		return
+	}
+
+	// Use first match
+	immichURL := owner.assetURL(ctx, foundAssets[0].ID)
+	exact := matchedByHash || matchMethod == MethodKnownMap || matchMethod == MethodLibraryPath
+	if !exact {
+		m.logger("Matched by %s (hash mismatch): %s", matchMethod, mediaFile)
+	}
+	mapping := Mapping{
+		GoogleURL:   md.URL,
+		ImmichURL:   immichURL,
+		ImmichID:    foundAssets[0].ID,
+		JSONFile:    c.jsonPath,
+		Path:        mediaPath,
+		Hash:        outHash,
+		MatchMethod: matchMethod,
+		Title:       md.Title,
+		People:      personNames(md.People),
+		Visibility:  foundAssets[0].Visibility,
+		Library:     library,
+		InTrash:     foundAssets[0].IsTrashed,
+		Edited:      c.edited,
+		MotionPath:  c.motionPath,
+	}
+	if m.includeMetadata {
+		mapping.Latitude, mapping.Longitude = location(md)
+	}
+	if mapping.InTrash {
+		m.incr(&result.Stats.InTrash)
+		m.logger("Found in the Immich trash: %s", mediaFile)
+	}
+	if m.linkInAlbum {
+		mapping.AlbumURLs = owner.albumURLs(ctx, foundAssets[0].ID)
+	}
+	mapping.Source = m.albums.source(path.Dir(c.jsonPath))
+	if m.compareExif {
+		owner.checkExif(ctx, c, foundAssets[0].ID, &mapping, result)
+	}
+	m.addMapping(result, mapping)
+	m.countMatch(result, matchMethod, exact, mediaFile)
+	m.albums.addAsset(path.Dir(c.jsonPath), foundAssets[0].ID)
+
+	if len(foundAssets) > 1 {
+		m.logger("Warning: multiple Immich assets found for %s, using first match", mediaFile)
+	}
+}
+
+// searchByFilename searches Immich for an asset by its filename and narrows
+// the matches down with filter. The filename and its variants (see
+// filenameCandidates) are searched in order until one has matches.
+func (m *Mapper) searchByFilename(ctx context.Context, md *googlephotos.GoogleMetaData, mediaFile string, filter func([]*immich.Asset) []*immich.Asset) []*immich.Asset {
+	// Try with the original filename from metadata
+	searchName := md.Title
+	if searchName == "" {
+		searchName = mediaFile
+	}
+	// Apply the user's filename transform (e.g. IMG_(\d+) -> CAM_$1)
+	if m.filenameTransform != nil {
+		transformed := m.filenameTransform.ReplaceAllString(searchName, m.filenameReplacement)
+		if transformed != searchName {
+			m.logger("Filename transform: %s -> %s", searchName, transformed)
+			searchName = transformed
+		}
+	}
+
+	for _, candidate := range m.filenameCandidates(searchName) {
+		assets, err := m.searchAssetsByFilename(ctx, candidate.name)
+		if err != nil {
+			m.logger("Warning: failed to query Immich by filename for %s: %v", candidate.name, err)
+			continue
+		}
+		assets = filter(assets)
+		if candidate.unique && len(assets) > 1 {
+			m.logger("Ignoring %d ambiguous matches for %s as %s (%s)", len(assets), searchName, candidate.name, candidate.variant)
+			continue
+		}
+		if len(assets) > 0 {
+			return assets
+		}
+	}
 	return nil
 }
 
+// filterByGoogleTime narrows multiple assets down by the Google timestamp.
+// A single asset is returned as is. With --timestamp-fallback, the assets
+// are returned unfiltered if none is within the tolerance.
+func (m *Mapper) filterByGoogleTime(assets []*immich.Asset, md *googlephotos.GoogleMetaData) []*immich.Asset {
+	if len(assets) > 1 && md.PhotoTakenTime != nil {
+		googleTime := md.PhotoTakenTime.Time()
+		if !googleTime.IsZero() {
+			matches := filterByTimestamp(assets, googleTime, m.timezone, m.timestampTolerance)
+			if len(matches) > 0 || !m.timestampFallback {
+				return matches
+			}
+			m.logger("No match within %s of %s, keeping all %d matches of %s", m.timestampTolerance, googleTime.Format(time.RFC3339), len(assets), md.Title)
+		}
+	}
+	return assets
+}
+
+// counterRe matches a filename with a "(N)" counter before the extension.
+var counterRe = regexp.MustCompile(`^(.+?)\(\d+\)(\.[^.]+)?$`)
+
+// stripCounter removes a "(N)" counter before the extension:
+// "IMG_0001(1).jpg" -> "IMG_0001.jpg".
+func stripCounter(filename string) (string, bool) {
+	match := counterRe.FindStringSubmatch(filename)
+	if match == nil {
+		return "", false
+	}
+	return match[1] + match[2], true
+}
+
+// verifyChecksum asserts that the checksum Immich reports for a hash match
+// equals the hash that was searched for. Mismatches are counted and logged as
+// data-integrity warnings; the mapping itself is kept.
+func (m *Mapper) verifyChecksum(ctx context.Context, asset *immich.Asset, hash, mediaPath string, result *Result) {
+	checksum := asset.Checksum
+	if checksum == "" {
+		// Assets from the bulk check only carry their ID
+		details, err := m.getAsset(ctx, asset.ID)
+		if err != nil {
+			m.logger("Warning: failed to fetch Immich asset %s to verify its checksum: %v", asset.ID, err)
+			return
+		}
+		checksum = details.Checksum
+	}
+
+	if checksum != hash {
+		m.incr(&result.Stats.ChecksumMismatch)
+		m.logger("Warning: checksum mismatch for %s: searched %s, Immich asset %s reports %s", mediaPath, hash, asset.ID, checksum)
+	}
+}
+
+// addMapping records a mapping in the result, or passes it to the
+// OnMapping callback if one is configured.
+func (m *Mapper) addMapping(result *Result, mapping Mapping) {
+	m.trackMatch(mapping)
+	if m.onMapping == nil {
+		result.Mappings = append(result.Mappings, mapping)
+		return
+	}
+	if err := m.onMapping(mapping); err != nil && m.onMappingErr == nil {
+		m.onMappingErr = err
+	}
+}
+
+// addNotFound records an asset that wasn't found in the result and passes
+// it to the OnNotFound callback if one is configured.
+func (m *Mapper) addNotFound(result *Result, nf NotFound) {
+	result.NotFound = append(result.NotFound, nf)
+	if m.onNotFound == nil {
+		return
+	}
+	if err := m.onNotFound(nf); err != nil && m.onNotFoundErr == nil {
+		m.onNotFoundErr = err
+	}
+}
+
+// location returns the coordinates of an asset from its Google metadata:
+// the location shown in Google Photos (geoData), which may have been set by
+// hand, or else the one from the file's EXIF data (geoDataExif). Google
+// writes 0, 0 if there is none; then both are nil.
+func location(md *googlephotos.GoogleMetaData) (lat, lon *float64) {
+	for _, geo := range []*googlephotos.GoogGeoData{md.GeoData, md.GeoDataExif} {
+		if geo != nil && (geo.Latitude != 0 || geo.Longitude != 0) {
+			return &geo.Latitude, &geo.Longitude
+		}
+	}
+	return nil, nil
+}
+
+// personNames returns the names of the people tagged in the Google metadata.
+func personNames(people []googlephotos.Person) []string {
+	var names []string
+	for _, p := range people {
+		if p.Name != "" {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// orphanMatch is the outcome of findOrphan.
+type orphanMatch struct {
+	asset   *immich.Asset // nil if not found
+	missing bool          // known not to be in Immich, not just a failed search
+}
+
+// findOrphan checks whether an orphan media file exists in Immich. Like
+// findMatch, it runs for several files at once; matchOrphan records the
+// outcome. existing is used as in findMatch.
+func (m *Mapper) findOrphan(ctx context.Context, o orphanFile, existing map[string]string) orphanMatch {
+	if o.hashErr != nil {
+		m.logger("Orphan media: %s (hash error: %v)", o.path, o.hashErr)
+		return orphanMatch{}
+	}
+	m.logger("Orphan media: %s (hash: %s)", o.path, o.hash)
+
+	if id, ok := m.knownMap[o.hash]; ok {
+		return orphanMatch{asset: &immich.Asset{ID: id}}
+	}
+	if existing != nil {
+		id, ok := existing[o.hash]
+		if !ok {
+			return orphanMatch{missing: true}
+		}
+		asset, err := m.getAsset(ctx, id)
+		if err != nil {
+			m.logger("Warning: failed to fetch Immich asset %s: %v", id, err)
+			asset = &immich.Asset{ID: id}
+		}
+		return orphanMatch{asset: asset}
+	}
+	assets, err := m.searchAssetsByHash(ctx, o.hash)
+	if err != nil {
+		return orphanMatch{}
+	}
+	if len(assets) == 0 {
+		return orphanMatch{missing: true}
+	}
+	return orphanMatch{asset: assets[0]}
+}
+
+// matchOrphan records the outcome of findOrphan for an orphan media file in result.
+func (m *Mapper) matchOrphan(ctx context.Context, o orphanFile, match orphanMatch, result *Result) {
+	m.incr(&result.Stats.OrphanMedia)
+
+	orphan := OrphanMedia{Path: o.path}
+	defer func() {
+		result.OrphanMedia = append(result.OrphanMedia, orphan)
+	}()
+
+	if o.hashErr != nil {
+		return
+	}
+	orphan.Hash = m.outputHash(o.hash)
+	if match.missing {
+		m.addMissingOrphan(o)
+	}
+	asset := match.asset
+	if asset == nil {
+		return
+	}
+
+	orphan.ImmichURL = m.assetURL(ctx, asset.ID)
+	orphan.ImmichID = asset.ID
+	orphan.ImmichFilename = asset.OriginalFileName
+	orphan.InTrash = asset.IsTrashed || m.trashedIDs[asset.ID]
+
+	// Log if filename differs (for user awareness)
+	takeoutFilename := path.Base(o.path)
+	if asset.OriginalFileName != "" && asset.OriginalFileName != takeoutFilename {
+		m.logger("Filename mismatch: takeout=%s, immich=%s", takeoutFilename, asset.OriginalFileName)
+	}
+}
+
+// addMissingOrphan records an orphan that isn't in Immich for the upload manifest.
+func (m *Mapper) addMissingOrphan(o orphanFile) {
+	if m.trackMissing {
+		m.missingOrphans = append(m.missingOrphans, missingOrphan{archive: m.input, path: o.path})
+	}
+}
+
 // findMediaFile finds the media file corresponding to a JSON sidecar.
+// Names are compared after normalizeName, so titles with trailing spaces or
+// a different Unicode normalization still find their file.
 func (m *Mapper) findMediaFile(jsonName, title string, filesInDir []string) string {
 	// Remove .json extension to get base name
-	baseName := strings.TrimSuffix(jsonName, ".json")
+	baseName := normalizeName(sidecarBaseName(jsonName))
+
+	normalized := make([]string, len(filesInDir))
+	for i, f := range filesInDir {
+		normalized[i] = normalizeName(f)
+	}
+	find := func(name string) string {
+		for i, f := range normalized {
+			if f == name {
+				return filesInDir[i]
+			}
+		}
+		return ""
+	}
 
 	// Try exact match first (photo.jpg.json -> photo.jpg)
-	for _, f := range filesInDir {
-		if f == baseName {
+	if f := find(baseName); f != "" {
+		return f
+	}
+
+	// Google moves the (N) counter behind the extension in sidecar names:
+	// "photo(1).jpg" gets the sidecar "photo.jpg(1).json", so the counter
+	// belongs to the media file. This must be checked before the title,
+	// which is "photo.jpg" for both files.
+	if stem, ext, counter, ok := splitSidecarCounter(baseName); ok {
+		if f := find(stem + counter + ext); f != "" {
 			return f
 		}
 	}
 
 	// Try matching by title from metadata
-	if title != "" {
-		for _, f := range filesInDir {
-			if f == title {
-				return f
-			}
+	if title := normalizeName(title); title != "" {
+		if f := find(title); f != "" {
+			return f
 		}
 	}
 
 	// Handle Google's naming patterns:
 	// - photo.jpg.json -> photo.jpg
 	// - photo(1).jpg.json -> photo(1).jpg
-	// - photo.jpg(1).json -> photo.jpg (duplicate JSON)
+	// - photo.jpg(1).json -> photo(1).jpg, or photo.jpg if that doesn't exist (duplicate JSON)
 
 	// Check if baseName ends with a media extension
 	mediaExts := []string{".jpg", ".jpeg", ".png", ".gif", ".heic", ".heif", ".webp", ".mp4", ".mov", ".avi", ".mkv", ".3gp"}
 	for _, ext := range mediaExts {
 		if strings.HasSuffix(strings.ToLower(baseName), ext) {
-			for _, f := range filesInDir {
+			for i, f := range normalized {
 				if strings.EqualFold(f, baseName) {
-					return f
+					return filesInDir[i]
 				}
 			}
 		}
 	}
 
-	// Try removing trailing (N) from JSON name and find media file
-	// e.g., "photo.jpg(1).json" -> look for "photo.jpg"
-	if idx := strings.LastIndex(baseName, "("); idx > 0 {
-		possibleName := baseName[:idx]
-		for _, f := range filesInDir {
-			if f == possibleName {
-				return f
-			}
+	// Fall back to treating "photo.jpg(1).json" as a duplicate sidecar of "photo.jpg"
+	if stem, ext, _, ok := splitSidecarCounter(baseName); ok {
+		if f := find(stem + ext); f != "" {
+			return f
+		}
+	}
+
+	// Without the original, the sidecar belongs to its edited copy
+	for _, name := range []string{baseName, normalizeName(title)} {
+		if edited := findEditedFiles(name, filesInDir); len(edited) > 0 {
+			return edited[0]
 		}
 	}
 
 	return ""
 }
 
-// computeHash computes the SHA1 hash of a file and returns it as base64.
-func (m *Mapper) computeHash(fsys fs.FS, fpath string) (string, error) {
-	f, err := fsys.Open(fpath)
-	if err != nil {
-		return "", err
+// supplementalSuffix is the suffix recent takeouts add to sidecar names:
+// "photo.jpg.supplemental-metadata.json".
+const supplementalSuffix = "supplemental-metadata"
+
+// minSupplementalPrefix is the length a truncated supplemental suffix needs
+// at least ("sup"), so the last part of a dotted name like "a.b.s" isn't
+// taken for one.
+const minSupplementalPrefix = 3
+
+// supplementalRe matches a sidecar base name ending in the (possibly
+// truncated) supplemental suffix and an optional counter, e.g.
+// "photo.jpg.supplemental-met" or "photo.jpg.supplemental-metadata(1)".
+var supplementalRe = regexp.MustCompile(`^(.+\.[^.]+)\.([a-z-]+)(\(\d+\))?$`)
+
+// sidecarBaseName returns the name of a sidecar without ".json" and without
+// the ".supplemental-metadata" suffix of recent takeouts. Google cuts long
+// sidecar names to fit the file name limit, so a prefix of the suffix of at
+// least minSupplementalPrefix letters is stripped as well
+// ("IMG_1234.HEIC.supplemental-met.json" -> "IMG_1234.HEIC").
+// A counter is kept: "photo.jpg.supplemental-metadata(1).json" -> "photo.jpg(1)".
+func sidecarBaseName(jsonName string) string {
+	baseName := strings.TrimSuffix(jsonName, ".json")
+	if match := supplementalRe.FindStringSubmatch(baseName); match != nil && len(match[2]) >= minSupplementalPrefix && strings.HasPrefix(supplementalSuffix, match[2]) {
+		return match[1] + match[3]
 	}
-	defer f.Close()
+	return baseName
+}
 
-	h := sha1.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
-	}
+// normalizeName trims surrounding whitespace and converts a file name to
+// Unicode NFC. Google titles and the file names in the archive may use
+// composed or decomposed accents ("é" vs "e" + U+0301) for the same name.
+func normalizeName(name string) string {
+	return norm.NFC.String(strings.TrimSpace(name))
+}
+
+// sidecarCounterRe matches a sidecar base name with a trailing counter after
+// the media extension, e.g. "photo.jpg(1)".
+var sidecarCounterRe = regexp.MustCompile(`^(.+?)(\.[^.()]+)(\(\d+\))$`)
 
-	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+// splitSidecarCounter splits a sidecar base name like "photo.jpg(1)" into
+// its stem ("photo"), extension (".jpg") and counter ("(1)").
+func splitSidecarCounter(baseName string) (stem, ext, counter string, ok bool) {
+	match := sidecarCounterRe.FindStringSubmatch(baseName)
+	if match == nil {
+		return "", "", "", false
+	}
+	return match[1], match[2], match[3], true
 }
 
 // simpleMapping is the non-verbose mapping output.
@@ -491,12 +1907,17 @@ type simpleMapping struct {
 
 // simpleResult is the non-verbose result output.
 type simpleResult struct {
-	Mappings []simpleMapping `json:"mappings"`
+	Mappings       []simpleMapping `json:"mappings"`
+	Albums         []AlbumMapping  `json:"albums,omitempty"`
+	NotFoundAlbums []string        `json:"not_found_albums,omitempty"`
+	Archives       []DryRunArchive `json:"archives,omitempty"`
+	Stats          *Stats          `json:"stats,omitempty"`
 }
 
 // WriteJSON writes the result to a writer as JSON.
-// If verbose is false, only mappings with google_url and immich_url are included.
-func (r *Result) WriteJSON(w io.Writer, verbose bool) error {
+// If verbose is false, only mappings with google_url and immich_url are included,
+// plus the album mappings and the stats if includeStats is true.
+func (r *Result) WriteJSON(w io.Writer, verbose, includeStats bool) error {
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 
@@ -506,7 +1927,13 @@ func (r *Result) WriteJSON(w io.Writer, verbose bool) error {
 
 	// Non-verbose: only include simple mappings
 	simple := simpleResult{
-		Mappings: make([]simpleMapping, len(r.Mappings)),
+		Mappings:       make([]simpleMapping, len(r.Mappings)),
+		Albums:         r.Albums,
+		NotFoundAlbums: r.NotFoundAlbums,
+		Archives:       r.Archives,
+	}
+	if includeStats {
+		simple.Stats = &r.Stats
 	}
 	for i, m := range r.Mappings {
 		simple.Mappings[i] = simpleMapping{
@@ -517,115 +1944,294 @@ func (r *Result) WriteJSON(w io.Writer, verbose bool) error {
 	return enc.Encode(simple)
 }
 
+// mappingGroup is a section of mappings sharing the same match method.
+type mappingGroup struct {
+	Count    int         `json:"count"`
+	Mappings interface{} `json:"mappings"` // []Mapping or []simpleMapping
+}
+
+// groupedResult is the output grouped by match method.
+type groupedResult struct {
+	Groups map[string]mappingGroup `json:"groups"`
+	Stats  *Stats                  `json:"stats,omitempty"`
+}
+
+// WriteGroupedJSON writes the mappings grouped by match method as JSON,
+// so the reliable hash matches can be reviewed separately from the weaker ones.
+// If verbose is false, the mappings only include google_url and immich_url,
+// and the stats are only added if includeStats is true.
+func (r *Result) WriteGroupedJSON(w io.Writer, verbose, includeStats bool) error {
+	full := make(map[string][]Mapping)
+	simple := make(map[string][]simpleMapping)
+	for _, m := range r.Mappings {
+		full[m.MatchMethod] = append(full[m.MatchMethod], m)
+		simple[m.MatchMethod] = append(simple[m.MatchMethod], simpleMapping{
+			GoogleURL: m.GoogleURL,
+			ImmichURL: m.ImmichURL,
+		})
+	}
+
+	out := groupedResult{Groups: make(map[string]mappingGroup)}
+	for method, mappings := range full {
+		group := mappingGroup{Count: len(mappings), Mappings: simple[method]}
+		if verbose {
+			group.Mappings = mappings
+		}
+		out.Groups[method] = group
+	}
+	if verbose || includeStats {
+		out.Stats = &r.Stats
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// WriteAlbumPlanJSON writes the album plan (album name -> Immich asset IDs) as JSON.
+func (r *Result) WriteAlbumPlanJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.AlbumPlan)
+}
+
+// WriteCleanupJSON writes the cleanup report (see CleanupReport) as JSON.
+func (r *Result) WriteCleanupJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.Cleanup)
+}
+
+// WriteIDs writes the unique Immich asset IDs of all mappings, one per line,
+// in the order they were matched.
+func (r *Result) WriteIDs(w io.Writer) error {
+	seen := make(map[string]bool)
+	for _, mapping := range r.Mappings {
+		if seen[mapping.ImmichID] {
+			continue
+		}
+		seen[mapping.ImmichID] = true
+		if _, err := fmt.Fprintln(w, mapping.ImmichID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsResult is the summary-only result output.
+type statsResult struct {
+	Stats       Stats                    `json:"stats"`
+	StatsByType map[string]*TypeStats    `json:"stats_by_type,omitempty"`
+	PerArchive  map[string]*ArchiveStats `json:"per_archive,omitempty"`
+}
+
+// WriteStatsJSON writes only the stats of the result to a writer as JSON.
+func (r *Result) WriteStatsJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(statsResult{Stats: r.Stats, StatsByType: r.StatsByType, PerArchive: r.PerArchive})
+}
+
 // searchMetadataResponse matches the Immich API response structure.
 type searchMetadataResponse struct {
 	Assets struct {
-		Items []*immich.Asset `json:"items"`
+		Items    []*immich.Asset `json:"items"`
+		NextPage *string         `json:"nextPage"`
 	} `json:"assets"`
 }
 
+// Defaults of Config.SearchPageSize and Config.SearchMaxPages.
+const (
+	DefaultSearchPageSize = 100
+	DefaultSearchMaxPages = 10
+)
+
 // searchWithVisibility searches for assets using the Immich API with a specific visibility.
+// The result pages are read until the last one, or up to searchMaxPages.
 func (m *Mapper) searchWithVisibility(ctx context.Context, query map[string]interface{}, visibility string) ([]*immich.Asset, error) {
-	query["visibility"] = visibility
-	query["size"] = 100
-
-	body, err := json.Marshal(query)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", m.serverURL+"/api/search/metadata", bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", m.apiKey)
+	m.compat.applyVisibility(query, visibility)
+	query["size"] = m.searchPageSize
+
+	var assets []*immich.Asset
+	for page := 1; ; page++ {
+		query["page"] = page
+		var result searchMetadataResponse
+		if err := m.apiRequest(ctx, "POST", "/api/search/metadata", query, &result); err != nil {
+			return nil, err
+		}
+		assets = append(assets, result.Assets.Items...)
 
-	resp, err := m.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+		if len(result.Assets.Items) < m.searchPageSize || result.Assets.NextPage == nil {
+			return assets, nil
+		}
+		if page == m.searchMaxPages {
+			m.logger("Warning: search has more than %d pages of results, using the first %d assets", m.searchMaxPages, len(assets))
+			return assets, nil
+		}
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+// apiRequest performs a request against the Immich API and decodes the JSON response into out.
+// If body is non-nil, it is sent as JSON. Transient failures are retried
+// with backoff up to maxRetries times, if the request is idempotent.
+func (m *Mapper) apiRequest(ctx context.Context, method, apiPath string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
 	}
 
-	var result searchMetadataResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	for attempt := 0; ; attempt++ {
+		err := m.doRequest(ctx, method, apiPath, data, out)
+		if err == nil || attempt >= m.maxRetries || !idempotent(method, apiPath) || !retryable(ctx, err) {
+			return err
+		}
+		delay := retryDelay(m.retryDelay, attempt)
+		m.logger("Warning: %s %s failed (%v), retrying in %s", method, apiPath, err, delay.Round(time.Millisecond))
+		if !waitRetry(ctx, delay) {
+			return err
+		}
 	}
-
-	return result.Assets.Items, nil
 }
 
-// searchAssetsByHash searches for assets by hash across timeline and archive.
-func (m *Mapper) searchAssetsByHash(ctx context.Context, hash string) ([]*immich.Asset, error) {
-	query := map[string]interface{}{"checksum": hash}
+// doRequest sends a single API request with a JSON body (nil for none) and
+// decodes the JSON response into out.
+func (m *Mapper) doRequest(ctx context.Context, method, apiPath string, data []byte, out interface{}) error {
+	var reqBody io.Reader
+	if data != nil {
+		reqBody = bytes.NewReader(data)
+	}
 
-	// Try timeline first
-	assets, err := m.searchWithVisibility(ctx, query, "timeline")
+	req, err := http.NewRequestWithContext(ctx, method, m.serverURL+apiPath, reqBody)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if len(assets) > 0 {
-		return assets, nil
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("x-api-key", m.apiKey)
 
-	// Try archive
-	assets, err = m.searchWithVisibility(ctx, map[string]interface{}{"checksum": hash}, "archive")
+	resp, err := m.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{code: resp.StatusCode}
 	}
 
-	return assets, nil
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return err
+	}
+	// Read the rest (e.g. the trailing newline), so the connection can be reused
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
 }
 
-// searchAssetsByFilename searches for assets by filename across timeline and archive.
+// searchAssetsByFilename searches for assets by filename across timeline and archive
+// (see searchVisibilities).
 func (m *Mapper) searchAssetsByFilename(ctx context.Context, filename string) ([]*immich.Asset, error) {
-	query := map[string]interface{}{"originalFileName": filename}
+	return m.searchVisibilities(ctx, func() map[string]interface{} {
+		return map[string]interface{}{"originalFileName": filename, "withExif": true}
+	})
+}
 
-	// Try timeline first
-	assets, err := m.searchWithVisibility(ctx, query, "timeline")
-	if err != nil {
-		return nil, err
+// searchVisibilities runs the query built by newQuery for the preferred
+// visibility first (the timeline, unless --prefer-visibility archive), and
+// for the other one if nothing is found (not with --no-archive-search).
+// With --search-trash, the trash of both is searched last.
+// The visibility is recorded on assets from servers that don't report it.
+func (m *Mapper) searchVisibilities(ctx context.Context, newQuery func() map[string]interface{}) ([]*immich.Asset, error) {
+	visibilities := []string{VisibilityTimeline, VisibilityArchive}
+	if m.noArchiveSearch {
+		visibilities = visibilities[:1]
+	} else if m.preferVisibility == VisibilityArchive {
+		visibilities = []string{VisibilityArchive, VisibilityTimeline}
 	}
-	if len(assets) > 0 {
-		return assets, nil
+
+	for _, visibility := range visibilities {
+		assets, err := m.searchWithVisibility(ctx, newQuery(), visibility)
+		if err != nil {
+			return nil, err
+		}
+		if len(assets) > 0 {
+			for _, a := range assets {
+				if a.Visibility == "" {
+					a.Visibility = visibility
+				}
+			}
+			return assets, nil
+		}
 	}
 
-	// Try archive
-	assets, err = m.searchWithVisibility(ctx, map[string]interface{}{"originalFileName": filename}, "archive")
-	if err != nil {
-		return nil, err
+	if m.searchTrash {
+		return m.searchTrashed(ctx, newQuery, visibilities)
 	}
+	return nil, nil
+}
 
-	return assets, nil
+// searchTrashed runs the query with deleted assets included and keeps the
+// trashed ones, as the others were already searched by searchVisibilities.
+func (m *Mapper) searchTrashed(ctx context.Context, newQuery func() map[string]interface{}, visibilities []string) ([]*immich.Asset, error) {
+	for _, visibility := range visibilities {
+		query := newQuery()
+		query["withDeleted"] = true
+		assets, err := m.searchWithVisibility(ctx, query, visibility)
+		if err != nil {
+			return nil, err
+		}
+		var trashed []*immich.Asset
+		for _, a := range assets {
+			if a.IsTrashed {
+				if a.Visibility == "" {
+					a.Visibility = visibility
+				}
+				trashed = append(trashed, a)
+			}
+		}
+		if len(trashed) > 0 {
+			return trashed, nil
+		}
+	}
+	return nil, nil
 }
 
 // filterByTimestamp filters assets to find matches by timestamp.
-// Returns only assets that match within a 2 second tolerance.
-func filterByTimestamp(assets []*immich.Asset, targetTime time.Time) []*immich.Asset {
-	const tolerance = 2 * time.Second // Allow 2 second difference for timezone/rounding issues
-
+// Returns only assets that match within tolerance.
+//
+// targetTime is an absolute instant (Google stores UTC epochs). Immich's
+// fileCreatedAt and dateTimeOriginal are absolute instants too and are
+// compared directly. Immich's localDateTime, however, is the wall-clock time
+// the photo was taken, encoded as if it were UTC, so targetTime is first
+// converted to the wall clock of the photo's timezone: loc if given, else
+// the asset's EXIF timezone, else the local timezone.
+func filterByTimestamp(assets []*immich.Asset, targetTime time.Time, loc *time.Location, tolerance time.Duration) []*immich.Asset {
 	var matches []*immich.Asset
 
 	for _, a := range assets {
 		// Try different time fields from Immich asset
-		var assetTime time.Time
+		var assetTime, wantTime time.Time
 		if !a.LocalDateTime.Time.IsZero() {
-			assetTime = a.LocalDateTime.Time
+			assetTime = a.LocalDateTime.Time.UTC()
+			wantTime = wallClock(targetTime, assetLocation(a, loc))
 		} else if !a.FileCreatedAt.Time.IsZero() {
 			assetTime = a.FileCreatedAt.Time
+			wantTime = targetTime
 		} else if !a.ExifInfo.DateTimeOriginal.Time.IsZero() {
 			assetTime = a.ExifInfo.DateTimeOriginal.Time
+			wantTime = targetTime
 		}
 
 		if assetTime.IsZero() {
 			continue
 		}
 
-		diff := assetTime.Sub(targetTime)
+		diff := assetTime.Sub(wantTime)
 		if diff < 0 {
 			diff = -diff
 		}
@@ -642,3 +2248,23 @@ func filterByTimestamp(assets []*immich.Asset, targetTime time.Time) []*immich.A
 	// No match within tolerance, return empty to signal no match
 	return nil
 }
+
+// assetLocation returns the timezone used to derive an asset's wall-clock time.
+func assetLocation(a *immich.Asset, override *time.Location) *time.Location {
+	if override != nil {
+		return override
+	}
+	if a.ExifInfo.TimeZone != "" {
+		if loc, err := time.LoadLocation(a.ExifInfo.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// wallClock returns the wall-clock reading of t in loc, expressed as a UTC time.
+// This is the same encoding Immich uses for localDateTime.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	l := t.In(loc)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,216 @@
+package mapper
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/simulot/immich-go/immich"
+)
+
+func TestSidecarBaseName(t *testing.T) {
+	tests := []struct {
+		jsonName string
+		want     string
+	}{
+		{"IMG_1234.HEIC.supplemental-metadata.json", "IMG_1234.HEIC"},
+		{"IMG_1234.HEIC.supplemental-met.json", "IMG_1234.HEIC"},
+		{"IMG_1234.HEIC.suppl.json", "IMG_1234.HEIC"},
+		{"IMG_1234.HEIC.sup.json", "IMG_1234.HEIC"},
+		{"photo.jpg.supplemental-metadata(1).json", "photo.jpg(1)"},
+		{"photo.jpg.json", "photo.jpg"},
+		{"photo.jpg(1).json", "photo.jpg(1)"},
+		{"my.photo.jpg.json", "my.photo.jpg"},
+		{"my.photo.jpg.supplemental-metadata.json", "my.photo.jpg"},
+		// Too short for a truncated suffix
+		{"a.b.s.json", "a.b.s"},
+		{"a.b.su.json", "a.b.su"},
+		// Not a prefix of the suffix
+		{"photo.jpg.metadata.json", "photo.jpg.metadata"},
+	}
+	for _, tt := range tests {
+		if got := sidecarBaseName(tt.jsonName); got != tt.want {
+			t.Errorf("sidecarBaseName(%q) = %q, want %q", tt.jsonName, got, tt.want)
+		}
+	}
+}
+
+func TestFindMediaFileCounter(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonName string
+		title    string
+		files    []string
+		want     string
+	}{
+		{"counter belongs to the media file", "photo.jpg(1).json", "photo.jpg", []string{"photo.jpg", "photo(1).jpg"}, "photo(1).jpg"},
+		{"duplicate sidecar", "photo.jpg(1).json", "photo.jpg", []string{"photo.jpg"}, "photo.jpg"},
+		{"original", "photo.jpg.json", "photo.jpg", []string{"photo.jpg", "photo(1).jpg"}, "photo.jpg"},
+		{"counter before the extension", "photo(1).jpg.json", "photo.jpg", []string{"photo.jpg", "photo(1).jpg"}, "photo(1).jpg"},
+	}
+	m := &Mapper{}
+	for _, tt := range tests {
+		if got := m.findMediaFile(tt.jsonName, tt.title, tt.files); got != tt.want {
+			t.Errorf("%s: findMediaFile(%q, %v) = %q, want %q", tt.name, tt.jsonName, tt.files, got, tt.want)
+		}
+	}
+}
+
+func TestFindMediaFileUnicode(t *testing.T) {
+	m := &Mapper{}
+	// Decomposed title with a trailing space, composed file name
+	title := "Cafe\u0301.jpg "
+	files := []string{"other.jpg", "Caf\u00e9.jpg"}
+	if got := m.findMediaFile(title+".json", title, files); got != files[1] {
+		t.Errorf("findMediaFile by sidecar name = %q, want %q", got, files[1])
+	}
+	if got := m.findMediaFile("IMG_0001.jpg.json", title, files); got != files[1] {
+		t.Errorf("findMediaFile by title = %q, want %q", got, files[1])
+	}
+}
+
+// localAsset returns an asset with the given Immich localDateTime (the wall
+// clock encoded as UTC) and EXIF timezone.
+func localAsset(id, wall, zone string) *immich.Asset {
+	t, err := time.Parse("2006-01-02 15:04", wall)
+	if err != nil {
+		panic(err)
+	}
+	a := &immich.Asset{ID: id, LocalDateTime: immich.ImmichTime{Time: t}}
+	a.ExifInfo.TimeZone = zone
+	return a
+}
+
+func TestFilterByTimestampDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	tests := []struct {
+		name   string
+		target string // Google timestamp, UTC
+		assets []*immich.Asset
+		loc    *time.Location
+		want   []string
+	}{
+		{
+			// 02:00 CET jumps to 03:00 CEST, so 01:30Z is 03:30 local, not 02:30
+			"spring forward",
+			"2024-03-31T01:30:00Z",
+			[]*immich.Asset{localAsset("gap", "2024-03-31 02:30", "Europe/Berlin"), localAsset("cest", "2024-03-31 03:30", "Europe/Berlin")},
+			nil,
+			[]string{"cest"},
+		},
+		{
+			"before spring forward",
+			"2024-03-31T00:30:00Z",
+			[]*immich.Asset{localAsset("cet", "2024-03-31 01:30", "Europe/Berlin"), localAsset("cest", "2024-03-31 02:30", "Europe/Berlin")},
+			nil,
+			[]string{"cet"},
+		},
+		{
+			// 03:00 CEST falls back to 02:00 CET: 00:30Z and 01:30Z are both 02:30 local
+			"fall back, first pass",
+			"2024-10-27T00:30:00Z",
+			[]*immich.Asset{localAsset("overlap", "2024-10-27 02:30", "Europe/Berlin"), localAsset("after", "2024-10-27 03:30", "Europe/Berlin")},
+			nil,
+			[]string{"overlap"},
+		},
+		{
+			"fall back, second pass",
+			"2024-10-27T01:30:00Z",
+			[]*immich.Asset{localAsset("overlap", "2024-10-27 02:30", "Europe/Berlin"), localAsset("after", "2024-10-27 03:30", "Europe/Berlin")},
+			nil,
+			[]string{"overlap"},
+		},
+		{
+			// --timezone wins over the asset's EXIF timezone
+			"timezone override",
+			"2024-03-31T01:30:00Z",
+			[]*immich.Asset{localAsset("exif", "2024-03-30 21:30", "America/New_York"), localAsset("override", "2024-03-31 03:30", "America/New_York")},
+			berlin,
+			[]string{"override"},
+		},
+		{
+			"asset timezone",
+			"2024-03-31T01:30:00Z",
+			[]*immich.Asset{localAsset("exif", "2024-03-30 21:30", "America/New_York"), localAsset("berlin", "2024-03-31 03:30", "America/New_York")},
+			nil,
+			[]string{"exif"},
+		},
+	}
+	for _, tt := range tests {
+		target, err := time.Parse(time.RFC3339, tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, a := range filterByTimestamp(tt.assets, target, tt.loc, DefaultTimestampTolerance) {
+			got = append(got, a.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: filterByTimestamp = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDeterministicConcurrency(t *testing.T) {
+	tests := []struct {
+		concurrency, queryWorkers, warnings int
+	}{
+		{0, 0, 0},
+		{1, 1, 0},
+		{4, 0, 1},
+		{0, 8, 1},
+		{4, 8, 2},
+	}
+	for _, tt := range tests {
+		var warnings int
+		m, err := New(Config{Server: "http://127.0.0.1:2283", APIKey: "key", Deterministic: true, Concurrency: tt.concurrency, QueryWorkers: tt.queryWorkers, Logger: func(string, ...interface{}) { warnings++ }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.concurrency != 1 || m.queryWorkers != 1 {
+			t.Errorf("%+v: %d hash and %d query workers, want 1 and 1", tt, m.concurrency, m.queryWorkers)
+		}
+		if warnings != tt.warnings {
+			t.Errorf("%+v: %d warnings, want %d", tt, warnings, tt.warnings)
+		}
+	}
+}
+
+func TestQueryWorkersDefault(t *testing.T) {
+	m, err := New(Config{Server: "http://127.0.0.1:2283", APIKey: "key", Concurrency: 3, Logger: func(string, ...interface{}) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.concurrency != 3 || m.queryWorkers != 3 {
+		t.Errorf("%d hash and %d query workers, want 3 and 3", m.concurrency, m.queryWorkers)
+	}
+}
+
+func TestHTTPConnectionsDefault(t *testing.T) {
+	tests := []struct {
+		connections, concurrency, queryWorkers int
+		deterministic                          bool
+		want                                   int
+	}{
+		{0, 16, 0, false, 16},
+		{0, 2, 0, false, defaultHTTPConnections},
+		{0, 2, 32, false, 32},
+		{0, 16, 2, false, defaultHTTPConnections},
+		{0, 16, 0, true, defaultHTTPConnections},
+		{3, 16, 0, false, 3},
+	}
+	for _, tt := range tests {
+		m, err := New(Config{Server: "http://127.0.0.1:2283", APIKey: "key", HTTPConnections: tt.connections, Concurrency: tt.concurrency, QueryWorkers: tt.queryWorkers, Deterministic: tt.deterministic, Logger: func(string, ...interface{}) {}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := m.httpClient.Transport.(*http.Transport)
+		if m.httpConnections != tt.want || transport.MaxIdleConnsPerHost != tt.want {
+			t.Errorf("%+v: %d connections, %d idle per host, want %d", tt, m.httpConnections, transport.MaxIdleConnsPerHost, tt.want)
+		}
+	}
+}
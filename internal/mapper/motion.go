@@ -0,0 +1,38 @@
+package mapper
+
+import (
+	"path"
+	"strings"
+)
+
+// motionExtensions are the extensions of the video part of a motion photo:
+// iPhone live photos ("IMG_1234.HEIC" + "IMG_1234.MOV", exported by Google
+// as ".MP4") and Pixel motion photos with a separate video.
+var motionExtensions = map[string]bool{".mp4": true, ".mov": true}
+
+// findMotionFile returns the video part of a motion photo among the files of
+// its folder: a video with the same name up to the extension as the still
+// photo, compared like in findMediaFile but ignoring case. Videos with a
+// sidecar of their own (ownSidecar, by lower-case media name) are assets
+// themselves and not paired. It returns "" if mediaFile is no still photo
+// or has no video part.
+func findMotionFile(mediaFile string, filesInDir []string, ownSidecar map[string]bool) string {
+	name := normalizeName(mediaFile)
+	if name == "" || mediaTypeOf(name) != MediaTypePhoto {
+		return ""
+	}
+	stem := strings.TrimSuffix(name, path.Ext(name))
+
+	for _, f := range filesInDir {
+		n := normalizeName(f)
+		ext := path.Ext(n)
+		if !motionExtensions[strings.ToLower(ext)] || !strings.EqualFold(strings.TrimSuffix(n, ext), stem) {
+			continue
+		}
+		if ownSidecar[strings.ToLower(n)] {
+			continue
+		}
+		return f
+	}
+	return ""
+}
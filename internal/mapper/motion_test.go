@@ -0,0 +1,26 @@
+package mapper
+
+import "testing"
+
+func TestFindMotionFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		mediaFile  string
+		files      []string
+		ownSidecar map[string]bool
+		want       string
+	}{
+		{"HEIC and MP4", "IMG_1234.HEIC", []string{"IMG_1234.HEIC", "IMG_1234.MP4"}, nil, "IMG_1234.MP4"},
+		{"JPG and MP4", "PXL_0001.jpg", []string{"PXL_0001.jpg", "PXL_0001.mp4"}, nil, "PXL_0001.mp4"},
+		{"JPG and MOV", "IMG_1234.jpg", []string{"IMG_1234.jpg", "IMG_1234.mov"}, nil, "IMG_1234.mov"},
+		{"other name", "IMG_1234.HEIC", []string{"IMG_1234.HEIC", "IMG_1235.MP4"}, nil, ""},
+		{"no video", "IMG_1234.HEIC", []string{"IMG_1234.HEIC", "IMG_1234.jpg"}, nil, ""},
+		{"video with own sidecar", "IMG_1234.HEIC", []string{"IMG_1234.HEIC", "IMG_1234.MP4"}, map[string]bool{"img_1234.mp4": true}, ""},
+		{"video is no still", "IMG_1234.mp4", []string{"IMG_1234.mp4", "IMG_1234.mov"}, nil, ""},
+	}
+	for _, tt := range tests {
+		if got := findMotionFile(tt.mediaFile, tt.files, tt.ownSidecar); got != tt.want {
+			t.Errorf("%s: findMotionFile(%q, %v) = %q, want %q", tt.name, tt.mediaFile, tt.files, got, tt.want)
+		}
+	}
+}
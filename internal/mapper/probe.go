@@ -0,0 +1,149 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/simulot/immich-go/immich"
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/googlephotos"
+)
+
+// ProbeResult is what the matching finds in Immich for a single media file.
+type ProbeResult struct {
+	Hash string
+	// Sidecar is the JSON sidecar in the same folder that resolves to the
+	// file, empty if there is none. Without a sidecar, the filename tiers
+	// search by the file name and can't narrow matches by the taken time.
+	Sidecar string
+	Tiers   []ProbeTier
+}
+
+// ProbeTier lists the assets a matching tier finds for the probed file.
+type ProbeTier struct {
+	Tier   string
+	Assets []ProbeAsset
+}
+
+// ProbeAsset is an Immich asset found for the probed file.
+type ProbeAsset struct {
+	ID         string
+	URL        string
+	Filename   string
+	Visibility string
+	Albums     []string
+}
+
+// Probe connects to Immich and runs every matching tier for a single local
+// media file, as if it were part of a takeout, without walking an archive.
+func (m *Mapper) Probe(ctx context.Context, file string) (*ProbeResult, error) {
+	if m.client == nil {
+		return nil, fmt.Errorf("cannot probe files in dry-run mode")
+	}
+
+	fsys := os.DirFS(filepath.Dir(file))
+	name := filepath.Base(file)
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", file)
+	}
+
+	if err := m.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	hash, err := m.computeHash(ctx, fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute hash: %w", err)
+	}
+	result := &ProbeResult{Hash: hash}
+
+	c := candidate{
+		md:        &googlephotos.GoogleMetaData{Title: name},
+		mediaPath: name,
+		mediaFile: name,
+		size:      info.Size(),
+		hash:      hash,
+	}
+	if c.exif, err = readExif(fsys, name); err != nil {
+		m.logger("Warning: failed to read EXIF data of %s: %v", name, err)
+	}
+	if jsonName, md := m.findSidecar(fsys, name); md != nil {
+		result.Sidecar = filepath.Join(filepath.Dir(file), jsonName)
+		c.md, c.jsonPath = md, jsonName
+	}
+
+	for _, tier := range knownTiers {
+		pt := ProbeTier{Tier: tier}
+		for _, a := range m.runTier(ctx, tier, c, nil) {
+			pt.Assets = append(pt.Assets, m.probeAsset(ctx, a))
+		}
+		result.Tiers = append(result.Tiers, pt)
+	}
+	return result, nil
+}
+
+// findSidecar returns the JSON sidecar in the root of fsys that resolves
+// to the media file name, like in a takeout.
+func (m *Mapper) findSidecar(fsys fs.FS, name string) (string, *googlephotos.GoogleMetaData) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return "", nil
+	}
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			files = append(files, e.Name())
+		}
+	}
+	for _, jsonName := range files {
+		if !strings.HasSuffix(strings.ToLower(jsonName), ".json") {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, jsonName)
+		if err != nil {
+			continue
+		}
+		md, err := googlephotos.ParseMetadata(data)
+		if err != nil || !md.IsAsset() {
+			continue
+		}
+		if m.findMediaFile(jsonName, md.Title, files) == name {
+			return jsonName, md
+		}
+	}
+	return "", nil
+}
+
+// probeAsset fetches the details of an asset found by a tier.
+func (m *Mapper) probeAsset(ctx context.Context, a *immich.Asset) ProbeAsset {
+	// The bulk check and some searches only return the ID
+	if a.OriginalFileName == "" {
+		if full, err := m.getAsset(ctx, a.ID); err == nil {
+			if full.Visibility == "" {
+				full.Visibility = a.Visibility
+			}
+			a = full
+		}
+	}
+	p := ProbeAsset{
+		ID:         a.ID,
+		URL:        m.PhotoURL(a.ID),
+		Filename:   a.OriginalFileName,
+		Visibility: a.Visibility,
+	}
+	albums, err := m.assetAlbums(ctx, a.ID)
+	if err != nil {
+		m.logger("Warning: failed to query albums for asset %s: %v", a.ID, err)
+	}
+	for _, album := range albums {
+		p.Albums = append(p.Albums, album.AlbumName)
+	}
+	return p
+}
@@ -0,0 +1,69 @@
+package mapper
+
+import (
+	"context"
+	"path"
+)
+
+// progressTracker reports the progress of Run in steps, two per media file:
+// hashing it and matching it against Immich. The total is counted up front
+// by listing the inputs.
+type progressTracker struct {
+	report func(done, total int)
+	counts []int // steps per input
+	total  int
+	done   int
+	end    int // done after the current input
+}
+
+// countProgress lists the media files of all inputs for the progress total.
+// Inputs that can't be listed count as empty; opening them fails later.
+func (m *Mapper) countProgress(ctx context.Context) error {
+	p := m.progress
+	p.counts = make([]int, len(m.inputs))
+	for i, input := range m.inputs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := input.ListFiles(func(name string) {
+			if filename := path.Base(name); isMediaFile(filename) && !m.skipMediaType(filename) {
+				p.counts[i] += 2
+			}
+		})
+		if err != nil {
+			m.logger("Warning: failed to list %s for the progress: %v", input.Path, err)
+		}
+		p.total += p.counts[i]
+	}
+	p.report(0, p.total)
+	return nil
+}
+
+// startProgress starts counting the steps of input i.
+func (m *Mapper) startProgress(i int) {
+	if m.progress != nil {
+		m.progress.end = m.progress.done + m.progress.counts[i]
+	}
+}
+
+// advanceProgress counts a step as done. It doesn't go beyond the current
+// input's count, which misses files without extension.
+func (m *Mapper) advanceProgress() {
+	p := m.progress
+	if p == nil || p.done >= p.end {
+		return
+	}
+	p.done++
+	p.report(p.done, p.total)
+}
+
+// finishProgress counts all steps of the current input as done, including
+// those of skipped media files.
+func (m *Mapper) finishProgress() {
+	p := m.progress
+	if p == nil || p.done == p.end {
+		return
+	}
+	p.done = p.end
+	p.report(p.done, p.total)
+}
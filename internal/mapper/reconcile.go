@@ -0,0 +1,215 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// UploadEntry is a single file from an immich-go upload report.
+//
+// The expected report is a JSON array of entries, or an object with the
+// entries under "uploads", "assets" or "files":
+//
+//	{"uploads": [{"file": "Takeout/Google Photos/Photos from 2023/IMG_0001.jpg", "asset_id": "...", "status": "uploaded"}]}
+//
+// Field names are matched loosely (file/path/fileName, asset_id/assetId/id),
+// and all fields are optional. Entries with an error status are ignored.
+type UploadEntry struct {
+	File    string
+	AssetID string
+	Status  string
+}
+
+// UnmarshalJSON accepts the various field names of upload report entries.
+func (e *UploadEntry) UnmarshalJSON(data []byte) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	e.File = firstString(fields, "file", "path", "fileName", "filename", "originalPath")
+	e.AssetID = firstString(fields, "asset_id", "assetId", "assetID", "id")
+	e.Status = firstString(fields, "status", "action", "result")
+	return nil
+}
+
+func firstString(fields map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := fields[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// LoadUploadReport reads an immich-go upload report (see UploadEntry).
+func LoadUploadReport(path string) ([]UploadEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []UploadEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var wrapped struct {
+			Uploads []UploadEntry `json:"uploads"`
+			Assets  []UploadEntry `json:"assets"`
+			Files   []UploadEntry `json:"files"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("unrecognized upload report format: %w", err)
+		}
+		entries = append(append(wrapped.Uploads, wrapped.Assets...), wrapped.Files...)
+	}
+
+	valid := entries[:0]
+	for _, e := range entries {
+		status := strings.ToLower(e.Status)
+		if strings.Contains(status, "error") || strings.Contains(status, "fail") {
+			continue
+		}
+		if e.File == "" && e.AssetID == "" {
+			continue
+		}
+		valid = append(valid, e)
+	}
+	return valid, nil
+}
+
+// LoadMappings reads the mappings of a previous run's JSON output (default
+// or verbose). Without the verbose immich_id, the asset ID is taken from
+// the Immich URL.
+func LoadMappings(path string) ([]Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Mappings []Mapping `json:"mappings"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	for i, mapping := range out.Mappings {
+		if mapping.ImmichID == "" {
+			out.Mappings[i].ImmichID = assetIDFromURL(mapping.ImmichURL)
+		}
+	}
+	return out.Mappings, nil
+}
+
+// assetIDFromURL extracts the asset ID from an Immich photo URL.
+func assetIDFromURL(u string) string {
+	i := strings.LastIndex(u, "/photos/")
+	if i < 0 {
+		return ""
+	}
+	return strings.Trim(u[i+len("/photos/"):], "/")
+}
+
+// Reconciliation compares the mappings with an immich-go upload report.
+type Reconciliation struct {
+	UploadedNotMapped []UploadEntryJSON  `json:"uploaded_not_mapped"`
+	MappedNotUploaded []ReconcileMapping `json:"mapped_not_uploaded"`
+	Stats             ReconcileStats     `json:"stats"`
+}
+
+// UploadEntryJSON is the output form of an UploadEntry.
+type UploadEntryJSON struct {
+	File    string `json:"file,omitempty"`
+	AssetID string `json:"asset_id,omitempty"`
+}
+
+// ReconcileMapping is the output form of a Mapping without uploaded file.
+type ReconcileMapping struct {
+	GoogleURL string `json:"google_url"`
+	ImmichURL string `json:"immich_url"`
+	ImmichID  string `json:"immich_id,omitempty"`
+	Path      string `json:"path,omitempty"`
+}
+
+// ReconcileStats summarizes a Reconciliation.
+type ReconcileStats struct {
+	Uploaded          int `json:"uploaded"`
+	Mapped            int `json:"mapped"`
+	Reconciled        int `json:"reconciled"`
+	UploadedNotMapped int `json:"uploaded_not_mapped"`
+	MappedNotUploaded int `json:"mapped_not_uploaded"`
+}
+
+// Reconcile cross-references mappings with upload report entries. Entries
+// are matched by Immich asset ID, or by file path if the entry or the
+// mapping has no ID. Paths are compared by their part below the takeout's
+// "Google Photos" folder, as both tools may see different roots.
+func Reconcile(mappings []Mapping, uploads []UploadEntry) *Reconciliation {
+	r := &Reconciliation{
+		UploadedNotMapped: make([]UploadEntryJSON, 0),
+		MappedNotUploaded: make([]ReconcileMapping, 0),
+	}
+	r.Stats.Uploaded = len(uploads)
+	r.Stats.Mapped = len(mappings)
+
+	byID := make(map[string]bool)
+	byPath := make(map[string]bool)
+	for _, u := range uploads {
+		if u.AssetID != "" {
+			byID[u.AssetID] = true
+		}
+		if u.File != "" {
+			byPath[takeoutRelPath(u.File)] = true
+		}
+	}
+
+	mappedIDs := make(map[string]bool)
+	mappedPaths := make(map[string]bool)
+	for _, mapping := range mappings {
+		if mapping.ImmichID != "" {
+			mappedIDs[mapping.ImmichID] = true
+		}
+		if mapping.Path != "" {
+			mappedPaths[takeoutRelPath(mapping.Path)] = true
+		}
+
+		if byID[mapping.ImmichID] || (mapping.Path != "" && byPath[takeoutRelPath(mapping.Path)]) {
+			r.Stats.Reconciled++
+			continue
+		}
+		r.MappedNotUploaded = append(r.MappedNotUploaded, ReconcileMapping{
+			GoogleURL: mapping.GoogleURL,
+			ImmichURL: mapping.ImmichURL,
+			ImmichID:  mapping.ImmichID,
+			Path:      mapping.Path,
+		})
+	}
+
+	for _, u := range uploads {
+		if mappedIDs[u.AssetID] || (u.File != "" && mappedPaths[takeoutRelPath(u.File)]) {
+			continue
+		}
+		r.UploadedNotMapped = append(r.UploadedNotMapped, UploadEntryJSON{File: u.File, AssetID: u.AssetID})
+	}
+
+	r.Stats.UploadedNotMapped = len(r.UploadedNotMapped)
+	r.Stats.MappedNotUploaded = len(r.MappedNotUploaded)
+	return r
+}
+
+// takeoutRelPath returns the part of a path below "Google Photos/",
+// or the cleaned path if there is none.
+func takeoutRelPath(p string) string {
+	p = path.Clean(strings.ReplaceAll(p, "\\", "/"))
+	if i := strings.Index(p, "Google Photos/"); i >= 0 {
+		return p[i+len("Google Photos/"):]
+	}
+	return p
+}
+
+// WriteJSON writes the reconciliation as JSON.
+func (r *Reconciliation) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
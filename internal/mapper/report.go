@@ -0,0 +1,81 @@
+package mapper
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// markdownSpecial lists the characters escaped by markdownEscape.
+const markdownSpecial = "\\`*_{}[]()<>#+-.!|~"
+
+// markdownEscape escapes the characters with a meaning in markdown, so that
+// filenames and URLs are shown as they are.
+func markdownEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(markdownSpecial, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// WriteMarkdownReport writes a human-readable migration report as markdown:
+// the match rate, a stats table and collapsible lists of the not found and
+// orphan media files.
+func (r *Result) WriteMarkdownReport(w io.Writer) error {
+	s := r.Stats
+	var b strings.Builder
+
+	b.WriteString("# Google Photos to Immich Migration Report\n\n")
+	rate := 0.0
+	if s.TotalGoogleURLs > 0 {
+		rate = 100 * float64(s.Matched) / float64(s.TotalGoogleURLs)
+	}
+	fmt.Fprintf(&b, "> **Match rate: %.1f%%** (%d of %d Google URLs matched in Immich)\n\n", rate, s.Matched, s.TotalGoogleURLs)
+
+	b.WriteString("## Stats\n\n| | Count |\n|---|---:|\n")
+	rows := []struct {
+		label string
+		value int
+	}{
+		{"JSON files processed", s.TotalJSONFiles},
+		{"Google URLs found", s.TotalGoogleURLs},
+		{"Matched in Immich", s.Matched},
+		{"Matched by hash", s.MatchedByHash},
+		{"Matched by filename", s.MatchedByFilename},
+		{"Not found in Immich", s.NotFoundInImmich},
+		{"No media file for JSON", s.NoMediaFile},
+		{"Orphan media (no JSON)", s.OrphanMedia},
+		{"Hash computation errors", s.HashErrors},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(&b, "| %s | %d |\n", row.label, row.value)
+	}
+	for _, mediaType := range []string{MediaTypePhoto, MediaTypeVideo} {
+		if t := r.StatsByType[mediaType]; t != nil {
+			fmt.Fprintf(&b, "| Match rate %ss | %d of %d (%.1f%%) |\n", mediaType, t.Matched, t.Total, 100*t.MatchRate)
+		}
+	}
+
+	if len(r.NotFound) > 0 {
+		fmt.Fprintf(&b, "\n## Not Found\n\n<details>\n<summary>%d Google URLs not found in Immich</summary>\n\n| File | Google URL |\n|---|---|\n", len(r.NotFound))
+		for _, n := range r.NotFound {
+			fmt.Fprintf(&b, "| %s | %s |\n", markdownEscape(n.Path), markdownEscape(n.GoogleURL))
+		}
+		b.WriteString("\n</details>\n")
+	}
+
+	if len(r.OrphanMedia) > 0 {
+		fmt.Fprintf(&b, "\n## Orphan Media\n\n<details>\n<summary>%d media files without JSON sidecar</summary>\n\n| File | Immich URL |\n|---|---|\n", len(r.OrphanMedia))
+		for _, o := range r.OrphanMedia {
+			fmt.Fprintf(&b, "| %s | %s |\n", markdownEscape(o.Path), markdownEscape(o.ImmichURL))
+		}
+		b.WriteString("\n</details>\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,106 @@
+package mapper
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// normalizeServerURL validates the Immich server URL and strips trailing
+// slashes. A URL without scheme is an error, unless defaultHTTPS is set, in
+// which case https:// is assumed. With --normalize-immich-url, repeated
+// slashes in the path are collapsed as well.
+func (m *Mapper) normalizeServerURL(raw string, defaultHTTPS bool) (string, error) {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	if !strings.Contains(raw, "://") {
+		if !defaultHTTPS {
+			return "", fmt.Errorf("server URL %q has no scheme, use https://%s (or http:// for a local server)", raw, raw)
+		}
+		raw = "https://" + raw
+		m.logger("No scheme in server URL, using %s", raw)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid server URL %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid server URL %q: missing host", raw)
+	}
+
+	if u.Scheme == "http" && !isLocalHost(u.Hostname()) {
+		m.logger("Warning: %s uses plain http, the API key is sent unencrypted", raw)
+	}
+	if m.normalizeURLs && u.Path != "" {
+		// Clean the escaped path, so escaped slashes (%2F) stay as they are
+		escaped := strings.TrimSuffix(path.Clean(u.EscapedPath()), "/")
+		if u.Path, err = url.PathUnescape(escaped); err != nil {
+			return "", fmt.Errorf("invalid server URL %q: %w", raw, err)
+		}
+		u.RawPath = escaped
+		if u.String() != raw {
+			m.logger("Normalized server URL to %s", u.String())
+		}
+		raw = u.String()
+	}
+	return raw, nil
+}
+
+// webURL returns the URL of a page of the Immich web app, e.g.
+// m.webURL("photos", assetID). The elements are escaped and joined with
+// single slashes. With --normalize-immich-url, URLs that don't parse back
+// cleanly are logged.
+func (m *Mapper) webURL(elem ...string) string {
+	u, err := url.JoinPath(m.serverURL, elem...)
+	if err != nil {
+		// m.serverURL was validated in New, so this only happens for broken elements
+		u = m.serverURL + "/" + strings.Join(elem, "/")
+		m.logger("Warning: failed to build Immich URL %s: %v", u, err)
+		return u
+	}
+	if m.normalizeURLs {
+		if problem := checkWebURL(u); problem != "" {
+			m.logger("Warning: malformed Immich URL %s: %s", u, problem)
+		}
+	}
+	return u
+}
+
+// checkWebURL returns what is wrong with a produced Immich URL, or "" if it
+// parses cleanly: an absolute http(s) URL without empty path segments or a
+// trailing slash.
+func checkWebURL(raw string) string {
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		return err.Error()
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "scheme must be http or https"
+	case u.Host == "":
+		return "missing host"
+	case strings.Contains(u.Path, "//"):
+		return "empty path segment"
+	case strings.HasSuffix(u.Path, "/"):
+		return "trailing slash"
+	}
+	return ""
+}
+
+// PhotoURL returns the plain Immich URL of an asset (/photos/<assetId>).
+func (m *Mapper) PhotoURL(assetID string) string {
+	return m.webURL("photos", assetID)
+}
+
+// isLocalHost returns true for localhost and loopback addresses.
+func isLocalHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,56 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/simulot/immich-go/immich"
+)
+
+// SourceSharedAlbum is the Mapping.Source of assets in a Google shared album.
+const SourceSharedAlbum = "shared-album"
+
+// SharedLibrary is the Immich library of another user, searched for assets
+// of Google shared albums that aren't found in the own library.
+type SharedLibrary struct {
+	Name   string // reported as Mapping.Library
+	APIKey string // API key of the other user
+}
+
+// ParseSharedLibrary parses a shared library given as "name=api-key".
+func ParseSharedLibrary(s string) (SharedLibrary, error) {
+	name, key, ok := strings.Cut(s, "=")
+	name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+	if !ok || name == "" || key == "" {
+		return SharedLibrary{}, fmt.Errorf("invalid shared library %q, expected name=api-key", s)
+	}
+	return SharedLibrary{Name: name, APIKey: key}, nil
+}
+
+// forLibrary returns a copy of the mapper that sends its API requests with
+// the API key of lib. It shares the HTTP client and server settings.
+func (m *Mapper) forLibrary(lib SharedLibrary) *Mapper {
+	other := *m
+	other.apiKey = lib.APIKey
+	return &other
+}
+
+// searchSharedLibraries searches the shared libraries by hash, in order, and
+// returns the matches of the first library that has the asset, with a mapper
+// for further requests about them.
+func (m *Mapper) searchSharedLibraries(ctx context.Context, c candidate) ([]*immich.Asset, *Mapper, string) {
+	for _, lib := range m.sharedLibraries {
+		other := m.forLibrary(lib)
+		assets, err := other.searchAssetsByHash(ctx, c.hash)
+		if err != nil {
+			m.logger("Warning: failed to search shared library %s for %s: %v", lib.Name, c.mediaPath, err)
+			continue
+		}
+		if len(assets) > 0 {
+			m.logger("Found %s in shared library %s", c.mediaPath, lib.Name)
+			return assets, other, lib.Name
+		}
+	}
+	return nil, nil, ""
+}
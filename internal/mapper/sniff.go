@@ -0,0 +1,34 @@
+package mapper
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// sniffMedia checks the content of a file to see if it is a photo or video.
+// It is used for files without extension, which some exports produce.
+func sniffMedia(fsys fs.FS, fpath string) bool {
+	f, err := fsys.Open(fpath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	head = head[:n]
+
+	// ISO base media files (HEIC, MOV, MP4, 3GP) start with an ftyp box,
+	// which http.DetectContentType doesn't know for all brands.
+	if len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) {
+		return true
+	}
+	contentType := http.DetectContentType(head)
+	return strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/")
+}
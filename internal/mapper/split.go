@@ -0,0 +1,131 @@
+package mapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// splitIndex is the index file written by WriteSplitJSON.
+type splitIndex struct {
+	Parts    []string `json:"parts"`
+	Mappings int      `json:"mappings"`
+
+	// The album mappings always go to the index
+	Albums         []AlbumMapping `json:"albums,omitempty"`
+	NotFoundAlbums []string       `json:"not_found_albums,omitempty"`
+
+	// With verbose output, the other sections go to the index
+	NotFound          []NotFound               `json:"not_found,omitempty"`
+	OrphanMedia       []OrphanMedia            `json:"orphan_media,omitempty"`
+	Ambiguous         []Ambiguous              `json:"ambiguous,omitempty"`
+	SkippedLarge      []SkippedLarge           `json:"skipped_large,omitempty"`
+	BrowserMismatches []BrowserMismatch        `json:"browser_mismatches,omitempty"`
+	SuspiciousMatches []SuspiciousMatch        `json:"suspicious_matches,omitempty"`
+	StaleMappings     []Mapping                `json:"stale_mappings,omitempty"`
+	Stats             *Stats                   `json:"stats,omitempty"`
+	StatsByType       map[string]*TypeStats    `json:"stats_by_type,omitempty"`
+	PerArchive        map[string]*ArchiveStats `json:"per_archive,omitempty"`
+}
+
+// SplitPartName returns the name of the n-th part of a split output,
+// e.g. "output.001.json" for "output.json".
+func SplitPartName(target string, n int) string {
+	ext := path.Ext(target)
+	if ext == "" || strings.ContainsAny(ext, `/\`) {
+		ext = ""
+	}
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(target, ext), n, ext)
+}
+
+// WriteSplitJSON writes the mappings to parts of at most maxBytes each (a part
+// has at least one mapping), named by SplitPartName. Each part is a JSON array
+// of mappings. The target itself gets an index listing the parts, the album
+// mappings and, with verbose, the other sections of the result (with
+// includeStats, the stats). open creates the output files.
+func (r *Result) WriteSplitJSON(target string, maxBytes int64, verbose, includeStats bool, open func(name string) (io.WriteCloser, error)) error {
+	index := splitIndex{
+		Parts:          make([]string, 0),
+		Mappings:       len(r.Mappings),
+		Albums:         r.Albums,
+		NotFoundAlbums: r.NotFoundAlbums,
+	}
+
+	var part bytes.Buffer
+	flush := func() error {
+		if part.Len() == 0 {
+			return nil
+		}
+		part.WriteString("\n]\n")
+		name := SplitPartName(target, len(index.Parts)+1)
+		if err := writeAll(open, name, part.Bytes()); err != nil {
+			return err
+		}
+		index.Parts = append(index.Parts, path.Base(name))
+		part.Reset()
+		return nil
+	}
+
+	for _, m := range r.Mappings {
+		var v interface{} = m
+		if !verbose {
+			v = simpleMapping{GoogleURL: m.GoogleURL, ImmichURL: m.ImmichURL}
+		}
+		data, err := json.MarshalIndent(v, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		// 4 bytes for the separator and 3 for closing the array
+		if part.Len() > 0 && int64(part.Len()+len(data)+7) > maxBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if part.Len() == 0 {
+			part.WriteString("[\n  ")
+		} else {
+			part.WriteString(",\n  ")
+		}
+		part.Write(data)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if verbose {
+		index.NotFound = r.NotFound
+		index.OrphanMedia = r.OrphanMedia
+		index.Ambiguous = r.Ambiguous
+		index.SkippedLarge = r.SkippedLarge
+		index.BrowserMismatches = r.BrowserMismatches
+		index.SuspiciousMatches = r.SuspiciousMatches
+		index.StaleMappings = r.StaleMappings
+		index.Stats = &r.Stats
+		index.StatsByType = r.StatsByType
+		index.PerArchive = r.PerArchive
+	} else if includeStats {
+		index.Stats = &r.Stats
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAll(open, target, append(data, '\n'))
+}
+
+// writeAll writes data to a new output.
+func writeAll(open func(name string) (io.WriteCloser, error), name string, data []byte) error {
+	w, err := open(name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,197 @@
+package mapper
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	_ "modernc.org/sqlite" // pure-Go driver, registered as "sqlite"
+)
+
+// sqliteSchemaVersion is stored as the user_version of the database and
+// increased on incompatible changes of sqliteSchema.
+const sqliteSchemaVersion = 1
+
+// sqliteSchema creates the tables of the SQLite output. Empty values are
+// stored as NULL. The people and album URLs of a mapping are JSON arrays.
+var sqliteSchema = []string{
+	`CREATE TABLE mappings (
+		google_url   TEXT NOT NULL,
+		immich_url   TEXT NOT NULL,
+		immich_id    TEXT,
+		json_file    TEXT,
+		path         TEXT,
+		hash         TEXT,
+		match_method TEXT,
+		title        TEXT,
+		people       TEXT,
+		visibility   TEXT,
+		source       TEXT,
+		library      TEXT,
+		album_urls   TEXT
+	)`,
+	`CREATE INDEX mappings_google_url ON mappings (google_url)`,
+	`CREATE INDEX mappings_hash ON mappings (hash)`,
+	`CREATE INDEX mappings_immich_id ON mappings (immich_id)`,
+	`CREATE TABLE not_found (
+		google_url TEXT NOT NULL,
+		json_file  TEXT,
+		path       TEXT,
+		hash       TEXT,
+		reason     TEXT
+	)`,
+	`CREATE INDEX not_found_google_url ON not_found (google_url)`,
+	`CREATE INDEX not_found_hash ON not_found (hash)`,
+	`CREATE TABLE orphan_media (
+		path            TEXT NOT NULL,
+		hash            TEXT,
+		immich_url      TEXT,
+		immich_id       TEXT,
+		immich_filename TEXT
+	)`,
+	`CREATE INDEX orphan_media_hash ON orphan_media (hash)`,
+	`CREATE TABLE stats (
+		name  TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	)`,
+	fmt.Sprintf(`PRAGMA user_version = %d`, sqliteSchemaVersion),
+}
+
+// WriteSQLite writes the result as a SQLite database (see sqliteSchema).
+// The database is built in a temporary file, which is then copied to w.
+func (r *Result) WriteSQLite(w io.Writer) error {
+	dir, err := os.MkdirTemp("", "google-photos-immich-urls-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath := filepath.Join(dir, "output.sqlite")
+	if err := r.writeSQLiteFile(dbPath); err != nil {
+		return err
+	}
+
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeSQLiteFile creates the database at dbPath and fills it in one transaction.
+func (r *Result) writeSQLiteFile(dbPath string) error {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range sqliteSchema {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	insert := func(query string, rows int, args func(i int) []interface{}) error {
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for i := 0; i < rows; i++ {
+			if _, err := stmt.Exec(args(i)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = insert(`INSERT INTO mappings VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, len(r.Mappings), func(i int) []interface{} {
+		m := r.Mappings[i]
+		return []interface{}{m.GoogleURL, m.ImmichURL, nullString(m.ImmichID), nullString(m.JSONFile), nullString(m.Path),
+			nullString(m.Hash), nullString(m.MatchMethod), nullString(m.Title), jsonList(m.People),
+			nullString(m.Visibility), nullString(m.Source), nullString(m.Library), jsonList(m.AlbumURLs)}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write mappings: %w", err)
+	}
+
+	err = insert(`INSERT INTO not_found VALUES (?, ?, ?, ?, ?)`, len(r.NotFound), func(i int) []interface{} {
+		n := r.NotFound[i]
+		return []interface{}{n.GoogleURL, nullString(n.JSONFile), nullString(n.Path), nullString(n.Hash), nullString(n.Reason)}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write not_found: %w", err)
+	}
+
+	err = insert(`INSERT INTO orphan_media VALUES (?, ?, ?, ?, ?)`, len(r.OrphanMedia), func(i int) []interface{} {
+		o := r.OrphanMedia[i]
+		return []interface{}{o.Path, nullString(o.Hash), nullString(o.ImmichURL), nullString(o.ImmichID), nullString(o.ImmichFilename)}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write orphan_media: %w", err)
+	}
+
+	stats := r.Stats.values()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err = insert(`INSERT INTO stats VALUES (?, ?)`, len(names), func(i int) []interface{} {
+		return []interface{}{names[i], stats[names[i]]}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// values returns the stats by their JSON name. The matches per method are
+// named "matched_by_method.<method>".
+func (s Stats) values() map[string]int {
+	data, _ := json.Marshal(s)
+	var fields map[string]interface{}
+	_ = json.Unmarshal(data, &fields)
+
+	values := make(map[string]int)
+	for name, v := range fields {
+		if n, ok := v.(float64); ok {
+			values[name] = int(n)
+		}
+	}
+	for method, n := range s.MatchedByMethod {
+		values["matched_by_method."+method] = n
+	}
+	return values
+}
+
+// nullString returns nil for an empty string, so it is stored as NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// jsonList returns a list as JSON array, or nil for an empty list.
+func jsonList(list []string) interface{} {
+	if len(list) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(list)
+	return string(data)
+}
@@ -0,0 +1,67 @@
+package mapper
+
+// The counters of Result.Stats and Result.StatsByType are only updated
+// through these helpers, which hold statsMu, so they stay correct if assets
+// are processed in parallel. The Stats struct keeps its shape for the output;
+// it must only be read once the run is finished.
+
+// incr increments a counter of Result.Stats, e.g. m.incr(&result.Stats.HashErrors).
+func (m *Mapper) incr(counter *int) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	*counter++
+}
+
+// snapshotStats returns a copy of Result.Stats, e.g. to compute the stats of
+// one input. The copy shares MatchedByMethod with the result.
+func (m *Mapper) snapshotStats(result *Result) Stats {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	return result.Stats
+}
+
+// countMatch records a match with the given match_method. Exact matches
+// (by hash, known map or library path) count as matched_by_hash, the
+// others as matched_by_filename.
+func (m *Mapper) countMatch(result *Result, method string, exact bool, mediaFile string) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	s := &result.Stats
+	s.Matched++
+	if exact {
+		s.MatchedByHash++
+	} else {
+		s.MatchedByFilename++
+	}
+	if s.MatchedByMethod == nil {
+		s.MatchedByMethod = make(map[string]int)
+	}
+	s.MatchedByMethod[method]++
+	m.countTypeLocked(result, mediaFile, true)
+}
+
+// countType records the outcome of matching a media file in the stats of its type.
+func (m *Mapper) countType(result *Result, mediaFile string, matched bool) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	m.countTypeLocked(result, mediaFile, matched)
+}
+
+// countTypeLocked is countType with statsMu held.
+func (m *Mapper) countTypeLocked(result *Result, mediaFile string, matched bool) {
+	if result.StatsByType == nil {
+		result.StatsByType = make(map[string]*TypeStats)
+	}
+	t := result.StatsByType[mediaTypeOf(mediaFile)]
+	if t == nil {
+		t = &TypeStats{}
+		result.StatsByType[mediaTypeOf(mediaFile)] = t
+	}
+	t.Total++
+	if matched {
+		t.Matched++
+	} else {
+		t.NotFound++
+	}
+	t.MatchRate = float64(t.Matched) / float64(t.Total)
+}
@@ -0,0 +1,165 @@
+package mapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// StreamWriter writes the JSON output incrementally: the mappings are written
+// one by one as they are produced (see Config.OnMapping), the remaining
+// sections and the stats once the run is finished. The output has the same
+// shape as Result.WriteJSON, without keeping all mappings in memory.
+type StreamWriter struct {
+	w            io.Writer
+	verbose      bool
+	includeStats bool
+	lines        bool // JSON lines, see NewNDJSONWriter
+	count        int
+}
+
+// NewStreamWriter creates a StreamWriter. If verbose is false, only the
+// mappings with google_url and immich_url are written, plus the stats if
+// includeStats is true, like in WriteJSON.
+func NewStreamWriter(w io.Writer, verbose, includeStats bool) *StreamWriter {
+	return &StreamWriter{w: w, verbose: verbose, includeStats: includeStats}
+}
+
+// NewNDJSONWriter creates a StreamWriter for JSON lines (ndjson): every
+// mapping is a JSON object on a line of its own, with google_url and
+// immich_url only if verbose is false. The last line is an object with the
+// stats and, if verbose, the other sections of the result.
+func NewNDJSONWriter(w io.Writer, verbose bool) *StreamWriter {
+	return &StreamWriter{w: w, verbose: verbose, lines: true}
+}
+
+// WriteMapping writes a single mapping.
+func (s *StreamWriter) WriteMapping(m Mapping) error {
+	var v interface{} = m
+	if !s.verbose {
+		v = simpleMapping{GoogleURL: m.GoogleURL, ImmichURL: m.ImmichURL}
+	}
+	if s.lines {
+		return s.writeLine(v)
+	}
+	data, err := json.MarshalIndent(v, "    ", "  ")
+	if err != nil {
+		return err
+	}
+
+	prefix := ",\n    "
+	if s.count == 0 {
+		prefix = "{\n  \"mappings\": [\n    "
+	}
+	s.count++
+	_, err = fmt.Fprintf(s.w, "%s%s", prefix, data)
+	return err
+}
+
+// Finish closes the mappings list and writes the other sections of the result.
+// Mappings in r.Mappings are ignored, they must have been written with WriteMapping.
+func (s *StreamWriter) Finish(r *Result) error {
+	if s.lines {
+		return s.finishLines(r)
+	}
+	if s.count == 0 {
+		if _, err := io.WriteString(s.w, "{\n  \"mappings\": []"); err != nil {
+			return err
+		}
+	} else if _, err := io.WriteString(s.w, "\n  ]"); err != nil {
+		return err
+	}
+
+	if s.verbose {
+		// Encode the result without the mappings and append everything after them
+		rest := *r
+		rest.Mappings = nil
+		data, err := json.MarshalIndent(&rest, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = bytes.TrimSuffix(data, []byte("\n}"))
+		if i := bytes.Index(data, []byte("\n")); i >= 0 {
+			data = data[i:] // skip `{` on the first line
+		}
+		if i := bytes.Index(data, []byte(",\n")); i >= 0 {
+			if _, err := s.w.Write(data[i:]); err != nil { // skip the `"mappings": null` line
+				return err
+			}
+		}
+	} else {
+		if len(r.Albums) > 0 {
+			if err := s.writeSection("albums", r.Albums); err != nil {
+				return err
+			}
+		}
+		if len(r.NotFoundAlbums) > 0 {
+			if err := s.writeSection("not_found_albums", r.NotFoundAlbums); err != nil {
+				return err
+			}
+		}
+		if s.includeStats {
+			if err := s.writeSection("stats", &r.Stats); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := io.WriteString(s.w, "\n}\n")
+	return err
+}
+
+// writeSection writes a section of the non-verbose output after the mappings.
+func (s *StreamWriter) writeSection(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, ",\n  %q: %s", name, data)
+	return err
+}
+
+// writeLine writes v as a JSON line.
+func (s *StreamWriter) writeLine(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.count++
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// finishLines writes the last line of the JSON lines output.
+func (s *StreamWriter) finishLines(r *Result) error {
+	if !s.verbose {
+		return s.writeLine(struct {
+			Stats *Stats `json:"stats"`
+		}{&r.Stats})
+	}
+
+	// The result without the mappings, which are on the lines before
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	var rest map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rest); err != nil {
+		return err
+	}
+	delete(rest, "mappings")
+	return s.writeLine(rest)
+}
+
+// WriteNDJSON writes the result as JSON lines, like a StreamWriter created
+// with NewNDJSONWriter.
+func (r *Result) WriteNDJSON(w io.Writer, verbose bool) error {
+	s := NewNDJSONWriter(w, verbose)
+	for _, m := range r.Mappings {
+		if err := s.WriteMapping(m); err != nil {
+			return err
+		}
+	}
+	return s.Finish(r)
+}
@@ -0,0 +1,68 @@
+package mapper
+
+// SuspiciousMatch is an Immich asset that was matched by media files with
+// different hashes, which can't all be the same photo. Album copies of the
+// same photo have the same hash and don't count.
+type SuspiciousMatch struct {
+	ImmichURL  string   `json:"immich_url"`
+	ImmichID   string   `json:"immich_id"`
+	Hashes     []string `json:"hashes"`
+	GoogleURLs []string `json:"google_urls"`
+	Methods    []string `json:"match_methods"`
+}
+
+// matchedAsset is an Immich asset with the distinct hashes of the mappings to it.
+type matchedAsset struct {
+	immichURL string
+	hashes    []matchedHash
+}
+
+// matchedHash is a distinct hash that a mapping to an Immich asset came from.
+type matchedHash struct {
+	hash      string
+	googleURL string
+	method    string
+}
+
+// trackMatch remembers the hash a mapping came from, for the suspicious
+// match check. Mappings without hash (found by path) are exact and skipped.
+func (m *Mapper) trackMatch(mapping Mapping) {
+	if mapping.Hash == "" {
+		return
+	}
+	if m.matched == nil {
+		m.matched = make(map[string]*matchedAsset)
+	}
+	a, ok := m.matched[mapping.ImmichID]
+	if !ok {
+		a = &matchedAsset{immichURL: mapping.ImmichURL}
+		m.matched[mapping.ImmichID] = a
+		m.matchedIDs = append(m.matchedIDs, mapping.ImmichID)
+	}
+	for _, h := range a.hashes {
+		if h.hash == mapping.Hash {
+			return
+		}
+	}
+	a.hashes = append(a.hashes, matchedHash{mapping.Hash, mapping.GoogleURL, mapping.MatchMethod})
+}
+
+// findSuspiciousMatches lists the Immich assets matched from more than one
+// distinct hash, in the order they were first matched. These are usually
+// wrong matches of the filename tiers.
+func (m *Mapper) findSuspiciousMatches(result *Result) {
+	for _, id := range m.matchedIDs {
+		a := m.matched[id]
+		if len(a.hashes) < 2 {
+			continue
+		}
+		s := SuspiciousMatch{ImmichURL: a.immichURL, ImmichID: id}
+		for _, h := range a.hashes {
+			s.Hashes = append(s.Hashes, h.hash)
+			s.GoogleURLs = append(s.GoogleURLs, h.googleURL)
+			s.Methods = append(s.Methods, h.method)
+		}
+		result.SuspiciousMatches = append(result.SuspiciousMatches, s)
+		m.logger("Warning: suspicious match: Immich asset %s was matched by %d files with different hashes", id, len(a.hashes))
+	}
+}
@@ -0,0 +1,53 @@
+package mapper
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// WriteTable writes the mappings as an aligned text table followed by the
+// stats, for quick inspection in a terminal. URLs are shortened with an
+// ellipsis so that rows fit into width columns; width <= 0 disables this.
+func (r *Result) WriteTable(w io.Writer, width int) error {
+	methodWidth := len("METHOD")
+	for _, mapping := range r.Mappings {
+		methodWidth = max(methodWidth, len(mapping.MatchMethod))
+	}
+
+	// Split the remaining width between both URLs (2 spaces between columns)
+	googleWidth, immichWidth := 0, 0
+	if width > 0 {
+		urlWidth := max(width-methodWidth-4, 20)
+		googleWidth = urlWidth / 2
+		immichWidth = urlWidth - googleWidth
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GOOGLE URL\tIMMICH URL\tMETHOD")
+	for _, mapping := range r.Mappings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n",
+			truncate(mapping.GoogleURL, googleWidth),
+			truncate(mapping.ImmichURL, immichWidth),
+			mapping.MatchMethod)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := r.Stats
+	_, err := fmt.Fprintf(w, "\n%d of %d Google URLs matched (%d by hash, %d by filename), %d not found, %d without media file, %d orphan media\n",
+		s.Matched, s.TotalGoogleURLs, s.MatchedByHash, s.MatchedByFilename, s.NotFoundInImmich, s.NoMediaFile, s.OrphanMedia)
+	return err
+}
+
+// truncate shortens s to n runes, ending with an ellipsis.
+// n <= 0 keeps s as is.
+func truncate(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
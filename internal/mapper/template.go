@@ -0,0 +1,116 @@
+package mapper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the helper functions available in output templates.
+var templateFuncs = template.FuncMap{
+	"urlencode":  url.QueryEscape,
+	"pathescape": url.PathEscape,
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    strings.ReplaceAll,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"join":       strings.Join,
+	"base":       filepath.Base,
+	"shellquote": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	},
+}
+
+// ParseOutputTemplate reads and parses a text/template file for custom output.
+// The template is executed with the *Result as its data.
+func ParseOutputTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Funcs(templateFuncs).Parse(string(data))
+}
+
+// SampleResult returns a small synthetic result with every section filled,
+// to try out an output template without a run (--validate-templates).
+func SampleResult() *Result {
+	const server = "https://immich.example.com"
+	return &Result{
+		Mappings: []Mapping{
+			{
+				GoogleURL:   "https://photos.google.com/photo/AF1QipSample1",
+				ImmichURL:   server + "/photos/00000000-0000-0000-0000-000000000001",
+				ImmichID:    "00000000-0000-0000-0000-000000000001",
+				JSONFile:    "Takeout/Google Photos/Vacation/IMG_0001.jpg.json",
+				Path:        "Takeout/Google Photos/Vacation/IMG_0001.jpg",
+				Hash:        "tgEW4081VyLe/DmjJngdUbKZF44=",
+				MatchMethod: TierHash,
+				Title:       "IMG_0001.jpg",
+				People:      []string{"Alice"},
+				Visibility:  VisibilityTimeline,
+				AlbumURLs:   []string{server + "/albums/00000000-0000-0000-0000-00000000000a/photos/00000000-0000-0000-0000-000000000001"},
+				Source:      SourceAlbumPrefix + "Vacation",
+			},
+			{
+				GoogleURL:   "https://photos.google.com/photo/AF1QipSample2",
+				ImmichURL:   server + "/photos/00000000-0000-0000-0000-000000000002",
+				ImmichID:    "00000000-0000-0000-0000-000000000002",
+				JSONFile:    "Takeout/Google Photos/Photos from 2023/IMG_0002.jpg.json",
+				Path:        "Takeout/Google Photos/Photos from 2023/IMG_0002.jpg",
+				Hash:        "oljGbC4vRjw53D0ZUwh+yWkqhAo=",
+				MatchMethod: TierFilenameTimestamp,
+				Title:       "IMG_0002.jpg",
+				Visibility:  VisibilityArchive,
+				ExifDiff:    []ExifDiff{{Field: "model", Takeout: "Pixel 7", Immich: "Pixel 7 Pro"}},
+				Source:      SourceLibrary,
+			},
+		},
+		NotFound: []NotFound{{
+			GoogleURL: "https://photos.google.com/photo/AF1QipSample3",
+			JSONFile:  "Takeout/Google Photos/Photos from 2023/IMG_0003.jpg.json",
+			Path:      "Takeout/Google Photos/Photos from 2023/IMG_0003.jpg",
+			Hash:      "RL/OmoUIPg4qb9TRBiSqRQkvOTI=",
+		}},
+		OrphanMedia: []OrphanMedia{{
+			Path:           "Takeout/Google Photos/Photos from 2023/IMG_0004-edited.jpg",
+			Hash:           "2UW9hXWkvyBYYMwSAIMaAm+3LfA=",
+			ImmichURL:      server + "/photos/00000000-0000-0000-0000-000000000004",
+			ImmichID:       "00000000-0000-0000-0000-000000000004",
+			ImmichFilename: "IMG_0004.jpg",
+		}},
+		Stats: Stats{
+			TotalJSONFiles:    4,
+			TotalGoogleURLs:   3,
+			Matched:           2,
+			MatchedByHash:     1,
+			MatchedByFilename: 1,
+			MatchedByMethod:   map[string]int{TierHash: 1, TierFilenameTimestamp: 1},
+			NotFoundInImmich:  1,
+			OrphanMedia:       1,
+		},
+		StatsByType: map[string]*TypeStats{
+			MediaTypePhoto: {Total: 3, Matched: 2, NotFound: 1, MatchRate: 2.0 / 3},
+		},
+		PerArchive: map[string]*ArchiveStats{
+			"takeout-001": {GoogleURLs: 3, Matched: 2, NotFound: 1, OrphanMedia: 1},
+		},
+	}
+}
+
+// WriteTemplate writes the result using a custom output template.
+func (r *Result) WriteTemplate(w io.Writer, tmpl *template.Template) error {
+	return tmpl.Execute(w, r)
+}
@@ -0,0 +1,267 @@
+package mapper
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/simulot/immich-go/immich"
+)
+
+// Matching tiers, tried in the configured order until one finds an asset.
+// The tier name is used as the mapping's match_method.
+const (
+	TierHash              = "hash"               // SHA1 checksum
+	TierFilenameTimestamp = "filename+timestamp" // filename, multiple matches narrowed by taken time
+	TierFilenameSize      = "filename+size"      // filename and exact file size
+	TierTranscodedHEIC    = "transcoded-heic"    // HEIC stored as JPEG in Immich, by filename and taken time
+	TierTakenTime         = "taken-time"         // only asset of the media type taken within a window
+	TierCameraFingerprint = "camera-fingerprint" // filename and camera make, model and lens from EXIF
+)
+
+// DefaultTakenTimeWindow is the default tolerance of the taken-time tier on
+// each side of the Google timestamp, and MaxTakenTimeWindow its upper bound:
+// wider windows rarely contain a single asset and mostly find wrong ones.
+const (
+	DefaultTakenTimeWindow = 2 * time.Second
+	MaxTakenTimeWindow     = 10 * time.Minute
+)
+
+// DefaultTimestampTolerance is the default tolerance of the Google timestamp
+// when the filename tiers narrow down multiple matches, for rounding issues.
+const DefaultTimestampTolerance = 2 * time.Second
+
+// MethodKnownMap is the match_method of matches from the --known-map file,
+// which is consulted before all tiers.
+const MethodKnownMap = "known-map"
+
+// knownTiers lists all supported tiers.
+var knownTiers = []string{TierHash, TierFilenameTimestamp, TierFilenameSize, TierTranscodedHEIC, TierTakenTime, TierCameraFingerprint}
+
+// DefaultMatchTiers returns the tiers used without an explicit tier list:
+// hash only, plus filename+timestamp with --fallback-filename.
+func DefaultMatchTiers(fallbackFilename bool) []string {
+	if fallbackFilename {
+		return []string{TierHash, TierFilenameTimestamp}
+	}
+	return []string{TierHash}
+}
+
+// ParseMatchTiers parses a comma-separated list of matching tiers.
+func ParseMatchTiers(s string) ([]string, error) {
+	var tiers []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isKnownTier(name) {
+			return nil, fmt.Errorf("unknown match tier %q (supported: %s)", name, strings.Join(knownTiers, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("match tier %q listed twice", name)
+		}
+		seen[name] = true
+		tiers = append(tiers, name)
+	}
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("no match tiers given")
+	}
+	return tiers, nil
+}
+
+func isKnownTier(name string) bool {
+	for _, t := range knownTiers {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTier returns true if the given tier is enabled.
+func (m *Mapper) hasTier(tier string) bool {
+	for _, t := range m.tiers {
+		if t == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// runTier tries to find the Immich assets for a candidate with a single tier.
+// existing holds the asset IDs from the bulk existence check, keyed by hash;
+// if it is nil, the hash tier searches each asset individually.
+func (m *Mapper) runTier(ctx context.Context, tier string, c candidate, existing map[string]string) []*immich.Asset {
+	switch tier {
+	case TierHash:
+		if existing != nil {
+			if id, ok := existing[c.hash]; ok {
+				visibility := VisibilityTimeline
+				if m.archivedIDs[id] {
+					visibility = VisibilityArchive
+				}
+				return []*immich.Asset{{ID: id, IsTrashed: m.trashedIDs[id], Visibility: visibility}}
+			}
+			return nil
+		}
+		// Searches all visibility types
+		assets, err := m.searchAssetsByHash(ctx, c.hash)
+		if err != nil {
+			m.logger("Warning: failed to query Immich by hash for %s: %v", c.mediaPath, err)
+		}
+		return assets
+
+	case TierFilenameTimestamp:
+		return m.searchByFilename(ctx, c.md, c.mediaFile, func(assets []*immich.Asset) []*immich.Asset {
+			return m.filterByGoogleTime(assets, c.md)
+		})
+
+	case TierFilenameSize:
+		if c.size < 0 {
+			return nil
+		}
+		return m.searchByFilename(ctx, c.md, c.mediaFile, func(assets []*immich.Asset) []*immich.Asset {
+			return filterBySize(assets, c.size)
+		})
+
+	case TierTranscodedHEIC:
+		return m.searchTranscodedHEIC(ctx, c)
+
+	case TierTakenTime:
+		return m.searchByTakenTime(ctx, c)
+
+	case TierCameraFingerprint:
+		return m.searchByCameraFingerprint(ctx, c)
+	}
+	return nil
+}
+
+// searchTranscodedHEIC searches the JPEG that an import pipeline created from
+// a HEIC photo: "IMG_0001.HEIC" is searched as "IMG_0001.jpg" and, failing
+// that, by its base name, keeping only JPEG assets. Multiple matches are
+// narrowed down by the Google timestamp.
+func (m *Mapper) searchTranscodedHEIC(ctx context.Context, c candidate) []*immich.Asset {
+	name := c.md.Title
+	if name == "" {
+		name = c.mediaFile
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if ext != ".heic" && ext != ".heif" {
+		return nil
+	}
+	baseName := strings.TrimSuffix(name, path.Ext(name))
+
+	for _, searchName := range []string{baseName + ".jpg", baseName} {
+		assets, err := m.searchAssetsByFilename(ctx, searchName)
+		if err != nil {
+			m.logger("Warning: failed to query Immich by filename for %s: %v", searchName, err)
+			continue
+		}
+		var jpegs []*immich.Asset
+		for _, a := range assets {
+			switch strings.ToLower(path.Ext(a.OriginalFileName)) {
+			case ".jpg", ".jpeg":
+				jpegs = append(jpegs, a)
+			}
+		}
+		if jpegs = m.filterByGoogleTime(jpegs, c.md); len(jpegs) > 0 {
+			return jpegs
+		}
+	}
+	return nil
+}
+
+// searchByTakenTime searches the assets of the candidate's media type that
+// were taken within takenTimeWindow of the Google timestamp. Without the
+// content or the name to compare, only a single result counts as a match.
+func (m *Mapper) searchByTakenTime(ctx context.Context, c candidate) []*immich.Asset {
+	taken := c.md.PhotoTakenTime.Time()
+	if taken.IsZero() {
+		return nil
+	}
+	assetType := "IMAGE"
+	if mediaTypeOf(c.mediaFile) == MediaTypeVideo {
+		assetType = "VIDEO"
+	}
+
+	assets, err := m.searchVisibilities(ctx, func() map[string]interface{} {
+		return map[string]interface{}{
+			"takenAfter":  taken.Add(-m.takenTimeWindow).Format(time.RFC3339),
+			"takenBefore": taken.Add(m.takenTimeWindow).Format(time.RFC3339),
+			"type":        assetType,
+		}
+	})
+	if err != nil {
+		m.logger("Warning: failed to query Immich by taken time for %s: %v", c.mediaPath, err)
+		return nil
+	}
+	if len(assets) > 1 {
+		m.logger("Taken time of %s matches %d Immich assets, not using any", c.mediaPath, len(assets))
+		return nil
+	}
+	return assets
+}
+
+// searchByCameraFingerprint searches by filename and keeps the assets taken
+// with the same camera, for merged libraries where several people's cameras
+// produce the same filenames. The camera is compared by the EXIF make and
+// model, then the remaining assets are narrowed down by the Google timestamp
+// and, if the file has a lens model, by searching Immich for it.
+// Files without make and model in their EXIF data are skipped.
+func (m *Mapper) searchByCameraFingerprint(ctx context.Context, c candidate) []*immich.Asset {
+	if c.exif == nil || (c.exif.make == "" && c.exif.model == "") {
+		return nil
+	}
+
+	assets := m.searchByFilename(ctx, c.md, c.mediaFile, func(assets []*immich.Asset) []*immich.Asset {
+		var same []*immich.Asset
+		for _, a := range assets {
+			if strings.EqualFold(c.exif.make, strings.TrimSpace(a.ExifInfo.Make)) &&
+				strings.EqualFold(c.exif.model, strings.TrimSpace(a.ExifInfo.Model)) {
+				same = append(same, a)
+			}
+		}
+		return m.filterByGoogleTime(same, c.md)
+	})
+	if len(assets) <= 1 || c.exif.lens == "" {
+		return assets
+	}
+
+	// immich.Asset has no lens model, so let Immich filter by it
+	withLens, err := m.searchVisibilities(ctx, func() map[string]interface{} {
+		return map[string]interface{}{"originalFileName": assets[0].OriginalFileName, "lensModel": c.exif.lens}
+	})
+	if err != nil {
+		m.logger("Warning: failed to query Immich by lens model for %s: %v", c.mediaPath, err)
+		return assets
+	}
+	lensIDs := make(map[string]bool, len(withLens))
+	for _, a := range withLens {
+		lensIDs[a.ID] = true
+	}
+	var matches []*immich.Asset
+	for _, a := range assets {
+		if lensIDs[a.ID] {
+			matches = append(matches, a)
+		}
+	}
+	if len(matches) == 0 {
+		return assets
+	}
+	return matches
+}
+
+// filterBySize returns the assets whose file size in Immich equals size.
+func filterBySize(assets []*immich.Asset, size int64) []*immich.Asset {
+	var matches []*immich.Asset
+	for _, a := range assets {
+		if a.ExifInfo.FileSizeInByte == size {
+			matches = append(matches, a)
+		}
+	}
+	return matches
+}
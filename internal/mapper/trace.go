@@ -0,0 +1,85 @@
+package mapper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// redactedHeaders are logged without their value.
+var redactedHeaders = map[string]bool{
+	"X-Api-Key":     true,
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+}
+
+// tracingTransport logs every request to the Immich API and its response,
+// including the bodies, with the credentials redacted (see Config.Trace).
+type tracingTransport struct {
+	next  http.RoundTripper
+	trace func(format string, args ...interface{})
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody, _ = io.ReadAll(body)
+			body.Close()
+		}
+	}
+	t.trace("%s", traceEntry("> "+req.Method+" "+req.URL.RequestURI(), req.Header, reqBody))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.trace("< %s %s failed after %s: %v", req.Method, req.URL.RequestURI(), time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+
+	// Buffer the response, so it can be logged and still be read by the caller
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if err != nil {
+		return nil, err
+	}
+	status := fmt.Sprintf("< %s %s: %s (%s)", req.Method, req.URL.RequestURI(), resp.Status, time.Since(start).Round(time.Millisecond))
+	t.trace("%s", traceEntry(status, resp.Header, respBody))
+	return resp, nil
+}
+
+// traceEntry formats a request or response: the first line, the headers and,
+// after an empty line, the body.
+func traceEntry(first string, h http.Header, body []byte) string {
+	entry := first + "\n" + traceHeaders(h)
+	if body = bytes.TrimSpace(body); len(body) > 0 {
+		entry += "\n" + string(body)
+	}
+	return strings.TrimSuffix(entry, "\n")
+}
+
+// traceHeaders formats headers as sorted "Name: value" lines, with the
+// values of redactedHeaders replaced.
+func traceHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			value = "[REDACTED]"
+		}
+		b.WriteString(name + ": " + value + "\n")
+	}
+	return b.String()
+}
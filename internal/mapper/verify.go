@@ -0,0 +1,72 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// verifyMappings checks that the Immich asset of every mapping still exists
+// (--verify-urls), as assets may have been deleted or re-imported since the
+// server was searched. Mappings of missing assets are moved from
+// result.Mappings to result.StaleMappings. Each asset is fetched once, with
+// the API key of the library it was found in.
+func (m *Mapper) verifyMappings(ctx context.Context, result *Result) error {
+	type asset struct {
+		id      string
+		library string
+	}
+	var assets []asset
+	seen := make(map[asset]bool)
+	for _, mapping := range result.Mappings {
+		a := asset{mapping.ImmichID, mapping.Library}
+		if !seen[a] {
+			seen[a] = true
+			assets = append(assets, a)
+		}
+	}
+
+	owners := map[string]*Mapper{"": m}
+	for _, lib := range m.sharedLibraries {
+		owners[lib.Name] = m.forLibrary(lib)
+	}
+
+	stale := make(map[asset]bool)
+	err := runOrdered(ctx, m.queryWorkers, len(assets), func(ctx context.Context, i int) error {
+		owner := owners[assets[i].library]
+		if owner == nil {
+			owner = m
+		}
+		_, err := owner.getAsset(ctx, assets[i].id)
+		return err
+	}, func(i int, err error) error {
+		var status *statusError
+		switch {
+		case err == nil:
+		case errors.As(err, &status) && (status.code == http.StatusNotFound || status.code == http.StatusBadRequest):
+			// Immich answers 400 for asset IDs that don't exist (anymore)
+			stale[assets[i]] = true
+		case ctx.Err() != nil:
+			return ctx.Err()
+		default:
+			m.logger("Warning: failed to verify Immich asset %s: %v", assets[i].id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	kept := result.Mappings[:0]
+	for _, mapping := range result.Mappings {
+		if !stale[asset{mapping.ImmichID, mapping.Library}] {
+			kept = append(kept, mapping)
+			continue
+		}
+		m.incr(&result.Stats.StaleMappings)
+		m.logger("Stale mapping: Immich asset %s of %s doesn't exist anymore", mapping.ImmichID, mapping.Path)
+		result.StaleMappings = append(result.StaleMappings, mapping)
+	}
+	result.Mappings = kept
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package output writes the mappings as Google URL -> Immich URL pairs in
+// formats for rewriting links in notes, without the rest of the result.
+package output
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/mapper"
+)
+
+// pairs returns the Google URL -> Immich URL pairs of the mappings, in
+// mapping order. A Google URL that was mapped more than once keeps its first
+// Immich URL.
+func pairs(mappings []mapper.Mapping) [][2]string {
+	seen := make(map[string]bool)
+	var res [][2]string
+	for _, mapping := range mappings {
+		if seen[mapping.GoogleURL] {
+			continue
+		}
+		seen[mapping.GoogleURL] = true
+		res = append(res, [2]string{mapping.GoogleURL, mapping.ImmichURL})
+	}
+	return res
+}
+
+// WriteCSV writes the mappings as a google_url,immich_url CSV file with a
+// header row. Fields are quoted as in RFC 4180 where needed.
+func WriteCSV(w io.Writer, mappings []mapper.Mapping) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"google_url", "immich_url"}); err != nil {
+		return err
+	}
+	for _, p := range pairs(mappings) {
+		if err := cw.Write(p[:]); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteSed writes the mappings as a sed script with one s|google|immich|g
+// command per Google URL, to run over notes with sed -f. Longer URLs come
+// first, so a URL that is the prefix of another one doesn't replace a part
+// of it.
+func WriteSed(w io.Writer, mappings []mapper.Mapping) error {
+	sorted := pairs(mappings)
+	slices.SortStableFunc(sorted, func(a, b [2]string) int {
+		return cmp.Compare(len(b[0]), len(a[0]))
+	})
+	for _, p := range sorted {
+		if _, err := fmt.Fprintf(w, "s|%s|%s|g\n", sedPattern(p[0]), sedReplacement(p[1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sedPatternEscaper escapes the characters that are special in a basic
+// regular expression, and the | delimiter. Slashes need no escaping, as
+// they aren't the delimiter.
+var sedPatternEscaper = strings.NewReplacer(
+	`\`, `\\`, `|`, `\|`, `.`, `\.`, `*`, `\*`, `[`, `\[`, `]`, `\]`, `^`, `\^`, `$`, `\$`, "\n", `\n`,
+)
+
+// sedReplacementEscaper escapes the characters that are special in the
+// replacement of the s command.
+var sedReplacementEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, `&`, `\&`, "\n", `\n`)
+
+// sedPattern returns s as a sed regular expression that only matches s.
+func sedPattern(s string) string {
+	return sedPatternEscaper.Replace(s)
+}
+
+// sedReplacement returns s as a sed replacement that inserts s literally.
+func sedReplacement(s string) string {
+	return sedReplacementEscaper.Replace(s)
+}
@@ -0,0 +1,133 @@
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/mapper"
+)
+
+func mappings(pairs ...string) []mapper.Mapping {
+	var res []mapper.Mapping
+	for i := 0; i < len(pairs); i += 2 {
+		res = append(res, mapper.Mapping{GoogleURL: pairs[i], ImmichURL: pairs[i+1]})
+	}
+	return res
+}
+
+func TestWriteCSV(t *testing.T) {
+	in := mappings(
+		"https://photos.google.com/photo/A", "https://immich.example/photos/1",
+		"https://photos.google.com/photo/B,C", "https://immich.example/photos/\"2\"",
+		"https://photos.google.com/photo/D\nE", "https://immich.example/photos/3",
+		"https://photos.google.com/photo/A", "https://immich.example/photos/other",
+	)
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "google_url,immich_url\n" +
+		"https://photos.google.com/photo/A,https://immich.example/photos/1\n" +
+		"\"https://photos.google.com/photo/B,C\",\"https://immich.example/photos/\"\"2\"\"\"\n" +
+		"\"https://photos.google.com/photo/D\nE\",https://immich.example/photos/3\n"
+	if buf.String() != want {
+		t.Errorf("WriteCSV =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || records[2][0] != "https://photos.google.com/photo/B,C" || records[2][1] != "https://immich.example/photos/\"2\"" || records[3][0] != "https://photos.google.com/photo/D\nE" {
+		t.Errorf("CSV doesn't read back: %q", records)
+	}
+}
+
+func TestSedEscaping(t *testing.T) {
+	tests := []struct {
+		in, pattern, replacement string
+	}{
+		{"a|b", `a\|b`, `a\|b`},
+		{"a&b", `a&b`, `a\&b`},
+		{`a\b`, `a\\b`, `a\\b`},
+		{"a.b", `a\.b`, `a.b`},
+		{"a[1]", `a\[1\]`, `a[1]`},
+		{"a*b^c$", `a\*b\^c\$`, `a*b^c$`},
+		{"https://x/a?b=c", `https://x/a?b=c`, `https://x/a?b=c`},
+	}
+	for _, tt := range tests {
+		if got := sedPattern(tt.in); got != tt.pattern {
+			t.Errorf("sedPattern(%q) = %q, want %q", tt.in, got, tt.pattern)
+		}
+		if got := sedReplacement(tt.in); got != tt.replacement {
+			t.Errorf("sedReplacement(%q) = %q, want %q", tt.in, got, tt.replacement)
+		}
+	}
+}
+
+func TestWriteSedOrder(t *testing.T) {
+	in := mappings(
+		"https://photos.google.com/photo/A", "https://immich.example/photos/1",
+		"https://photos.google.com/photo/ABC", "https://immich.example/photos/2",
+		"https://photos.google.com/photo/AB", "https://immich.example/photos/3",
+	)
+	var buf bytes.Buffer
+	if err := WriteSed(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := []string{
+		`s|https://photos\.google\.com/photo/ABC|https://immich.example/photos/2|g`,
+		`s|https://photos\.google\.com/photo/AB|https://immich.example/photos/3|g`,
+		`s|https://photos\.google\.com/photo/A|https://immich.example/photos/1|g`,
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("WriteSed =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWriteSedScript(t *testing.T) {
+	sed, err := exec.LookPath("sed")
+	if err != nil {
+		t.Skip("sed not found")
+	}
+	in := mappings(
+		"https://photos.google.com/photo/A", "https://immich.example/photos/1",
+		"https://photos.google.com/photo/AB", "https://immich.example/photos/2&x=1",
+		"https://photos.google.com/photo/C|D", `https://immich.example/photos/3\4`,
+		"https://photos.google.com/photo/E.F[1]", "https://immich.example/photos/5",
+	)
+	var script bytes.Buffer
+	if err := WriteSed(&script, in); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	scriptFile := filepath.Join(dir, "rewrite.sed")
+	if err := os.WriteFile(scriptFile, script.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	notes := "see https://photos.google.com/photo/A and https://photos.google.com/photo/AB\n" +
+		"also https://photos.google.com/photo/C|D, https://photos.google.com/photo/E.F[1]\n" +
+		"but not https://photos.google.com/photo/EXF[1] or https://photos-google-com/photo/A\n"
+	cmd := exec.Command(sed, "-f", scriptFile)
+	cmd.Stdin = strings.NewReader(notes)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("sed -f: %v", err)
+	}
+
+	want := "see https://immich.example/photos/1 and https://immich.example/photos/2&x=1\n" +
+		`also https://immich.example/photos/3\4, https://immich.example/photos/5` + "\n" +
+		"but not https://photos.google.com/photo/EXF[1] or https://photos-google-com/photo/A\n"
+	if string(out) != want {
+		t.Errorf("sed output =\n%s\nwant\n%s", out, want)
+	}
+}
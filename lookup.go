@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/mapper"
+)
+
+var lookupHashCmd = &cobra.Command{
+	Use:   "lookup-hash <hash>",
+	Short: "Find the Immich assets with a given SHA1 hash",
+	Long: `Queries Immich for assets with the given SHA1 hash and prints their IDs,
+URLs and filenames. The hash can be given as hex or base64 (as printed
+in the logs and the verbose output).
+
+Example:
+  google-photos-immich-urls lookup-hash -s https://immich.example.com -k YOUR_API_KEY tgEW4081VyLe/DmjJngdUbKZF44=`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLookupHash,
+}
+
+func init() {
+	rootCmd.AddCommand(lookupHashCmd)
+}
+
+func runLookupHash(cmd *cobra.Command, args []string) error {
+	if err := resolveConnection(); err != nil {
+		return err
+	}
+	if server == "" || apiKey == "" {
+		return fmt.Errorf("--server and --api-key (or --api-key-file, or IMMICH_SERVER and IMMICH_API_KEY) are required")
+	}
+
+	hash, err := mapper.NormalizeHash(args[0])
+	if err != nil {
+		return err
+	}
+
+	httpOpts, err := httpOptions()
+	if err != nil {
+		return err
+	}
+	if err := checkSearchPaging(); err != nil {
+		return err
+	}
+
+	tracer, closeTrace, err := openTrace()
+	if err != nil {
+		return err
+	}
+	defer closeTrace()
+
+	m, err := mapper.New(mapper.Config{
+		Server:          server,
+		APIKey:          apiKey,
+		SkipSSL:         skipSSL,
+		DefaultHTTPS:    defaultHTTPS,
+		NormalizeURLs:   normalizeURLs,
+		HTTPConnections: httpConnections,
+		HTTP:            httpOpts,
+		NoArchiveSearch: noArchiveSearch,
+		SearchTrash:     searchTrash,
+		SearchPageSize:  searchPageSize,
+		SearchMaxPages:  searchMaxPages,
+		Logger: func(format string, args ...interface{}) {
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		},
+		Trace: tracer,
+	})
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	assets, err := m.LookupHash(cmd.Context(), hash)
+	if err != nil {
+		return err
+	}
+
+	if len(assets) == 0 {
+		fmt.Fprintf(os.Stderr, "No Immich asset found for hash %s\n", hash)
+		return nil
+	}
+	for _, a := range assets {
+		fmt.Printf("%s\t%s\t%s\n", a.ID, m.PhotoURL(a.ID), a.OriginalFileName)
+	}
+	return nil
+}
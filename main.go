@@ -10,23 +10,105 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/destination"
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/fshelper"
 	"github.com/thedirtyfew/google-photos-immich-urls/internal/mapper"
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/output"
+	"golang.org/x/term"
 )
 
 var (
 	// CLI flags
 	server           string
 	apiKey           string
+	apiKeyFile       string
 	skipSSL          bool
 	dryRun           bool
+	dryRunArchives   bool
+	listOnly         bool
 	outputFile       string
 	fallbackFilename bool
 	verbose          bool
+	timezone         string
+	summaryOnly      bool
+	includeStats     bool
+	linkInAlbum      bool
+	mapAlbums        bool
+	albumPlanFile    string
+	filenameXform    string
+	filenameVariants string
+	filenamePrefix   string
+	deterministic    bool
+	noArchiveSearch  bool
+	searchTrash      bool
+	searchPageSize   int
+	searchMaxPages   int
+	outputTemplate   string
+	verifyChecksums  bool
+	groupBy          string
+	excludeHashFile  string
+	createAlbums     bool
+	albumConflict    string
+	inputFormat      string
+	streamOutput     bool
+	failOnMultiple   bool
+	matchTiers       string
+	outputFormat     string
+	verifyBrowser    bool
+	defaultHTTPS     bool
+	normalizeURLs    bool
+	httpConnections  int
+	requestTimeout   time.Duration
+	idleTimeout      time.Duration
+	maxRetries       int
+	retryDelay       time.Duration
+	maxIdleConns     int
+	maxConnsPerHost  int
+	knownMapFile     string
+	libraryRoot      string
+	takenTimeWindow  time.Duration
+	tsTolerance      time.Duration
+	tsFallback       bool
+	includeMetadata  bool
+	verifyURLs       bool
+	sniffContent     bool
+	splitOutput      string
+	outputBOM        bool
+	validateTmpl     bool
+	concurrency      int
+	queryWorkers     int
+	cacheFile        string
+	checkpointFile   string
+	mergeSplits      bool
+	noProgress       bool
+	mediaType        string
+	hashAlgo         string
+	fromDate         string
+	toDate           string
+	compareExif      bool
+	skipOrphans      bool
+	sharedLibraries  []string
+	cleanupFile      string
+	manifestFile     string
+	preferVis        string
+	maxFileSize      string
+	hashBufferSize   string
+	hashErrorsNF     bool
+	maxOpenArchives  int
+	trace            bool
+	traceFile        string
 )
 
 func main() {
@@ -51,21 +133,94 @@ Example:
 
 The output is a JSON file containing the URL mappings that can be used
 for find/replace operations in your notes or other documents.`,
-	Args: cobra.MinimumNArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if validateTmpl {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
 	RunE: run,
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&server, "server", "s", "", "Immich server address (e.g., https://immich.example.com)")
-	rootCmd.Flags().StringVarP(&apiKey, "api-key", "k", "", "Immich API key")
-	rootCmd.Flags().BoolVar(&skipSSL, "skip-verify-ssl", false, "Skip SSL certificate verification")
+	rootCmd.PersistentFlags().StringVarP(&server, "server", "s", "", "Immich server address (e.g., https://immich.example.com; default: $IMMICH_SERVER)")
+	rootCmd.PersistentFlags().StringVarP(&apiKey, "api-key", "k", "", "Immich API key (default: --api-key-file or $IMMICH_API_KEY)")
+	rootCmd.PersistentFlags().StringVar(&apiKeyFile, "api-key-file", "", "Read the Immich API key from the first line of this file")
+	rootCmd.PersistentFlags().BoolVar(&skipSSL, "skip-verify-ssl", false, "Skip SSL certificate verification")
+	rootCmd.PersistentFlags().IntVar(&httpConnections, "http-connections", 0, "Number of connections to keep open to the Immich server, opened before processing starts (default: --query-workers, at least 4)")
+	rootCmd.PersistentFlags().DurationVar(&requestTimeout, "request-timeout", mapper.DefaultRequestTimeout, "Timeout of each Immich API request, including reading the response")
+	rootCmd.PersistentFlags().DurationVar(&idleTimeout, "idle-timeout", mapper.DefaultIdleTimeout, "Close connections to the Immich server that were idle for this long")
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", 3, "Number of times an Immich API request is repeated after a network error or a 5xx response, 0 to fail at once")
+	rootCmd.PersistentFlags().DurationVar(&retryDelay, "retry-delay", mapper.DefaultRetryDelay, "Wait before the first retry of a failed request, doubled for every further one")
+	rootCmd.PersistentFlags().IntVar(&maxIdleConns, "max-idle-conns", 0, "Number of idle connections to keep open (default: --http-connections)")
+	rootCmd.PersistentFlags().IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "Maximum number of connections to the Immich server, 0 for no limit")
+	rootCmd.PersistentFlags().BoolVar(&defaultHTTPS, "default-https", false, "Assume https:// if the server address has no scheme")
+	rootCmd.PersistentFlags().BoolVar(&normalizeURLs, "normalize-immich-url", false, "Collapse repeated slashes in the server address and warn about every immich_url that doesn't parse cleanly")
 	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Don't connect to Immich, just list found URLs")
-	rootCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path (default: stdout)")
+	rootCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path, file:// or s3://bucket/key URL (default: stdout)")
 	rootCmd.Flags().BoolVar(&fallbackFilename, "fallback-filename", false, "Fall back to filename+timestamp matching if hash doesn't match (may produce wrong matches)")
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Include detailed output (not_found, orphan_media, stats, extra fields)")
-
-	rootCmd.MarkFlagRequired("server")
-	rootCmd.MarkFlagRequired("api-key")
+	rootCmd.Flags().BoolVar(&summaryOnly, "summary-only", false, "Only print the summary; skip the mappings output (with -o, write the stats JSON instead)")
+	rootCmd.Flags().BoolVar(&linkInAlbum, "link-in-album", false, "Link assets that belong to exactly one Immich album within that album")
+	rootCmd.Flags().BoolVar(&mapAlbums, "map-albums", false, "Map each Google album to the Immich album of the same title (albums and not_found_albums in the output)")
+	rootCmd.Flags().BoolVar(&verifyURLs, "verify-urls", false, "Fetch the Immich asset of every mapping after the run and move mappings of deleted assets to stale_mappings")
+	rootCmd.Flags().BoolVar(&includeMetadata, "include-metadata", false, "Add the latitude and longitude from the sidecars to the mappings (verbose output)")
+	rootCmd.Flags().StringVar(&albumPlanFile, "album-plan", "", "Write a JSON file mapping each Google album name to its matched Immich asset IDs")
+	rootCmd.Flags().StringVar(&filenameVariants, "filename-variants", "base,counter", "Comma-separated variants of the filename also searched by the filename tiers, in order: base, counter, extension, prefix, case (empty for none)")
+	rootCmd.Flags().StringVar(&filenamePrefix, "filename-prefix", "", "Prefix stripped from or added to filenames by the prefix filename variant (e.g. PXL_)")
+	rootCmd.Flags().StringVar(&filenameXform, "filename-transform", "", "Rewrite filenames before the filename fallback search, as REGEX=REPLACEMENT (e.g. 'IMG_(\\d+)=CAM_$1')")
+	rootCmd.Flags().BoolVar(&deterministic, "deterministic", false, "Process inputs and orphan media in sorted order, one media file at a time, for reproducible output (slower; overrides --concurrency and --query-workers)")
+	rootCmd.PersistentFlags().BoolVar(&trace, "trace", false, "Log every Immich API request and response, with the API key redacted")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "Write the --trace log to this file instead of stderr (implies --trace)")
+	rootCmd.PersistentFlags().BoolVar(&noArchiveSearch, "no-archive-search", false, "Only search the Immich timeline, not archived assets (halves the number of requests)")
+	rootCmd.PersistentFlags().BoolVar(&searchTrash, "search-trash", false, "Also search the Immich trash for assets that aren't found otherwise, marking the mappings with in_trash")
+	rootCmd.PersistentFlags().IntVar(&searchPageSize, "search-page-size", mapper.DefaultSearchPageSize, "Number of assets per page of an Immich search (at most 1000)")
+	rootCmd.PersistentFlags().IntVar(&searchMaxPages, "search-max-pages", mapper.DefaultSearchMaxPages, "Number of pages of an Immich search read at most, e.g. for a common filename")
+	rootCmd.Flags().StringVar(&outputTemplate, "output-template", "", "Go text/template file used to render the output instead of JSON")
+	rootCmd.Flags().BoolVar(&verifyChecksums, "verify-checksums", false, "Fetch the checksum of every hash match from Immich and warn if it differs")
+	rootCmd.Flags().StringVar(&groupBy, "group-by", "", "Group the mappings in the output (supported: method)")
+	rootCmd.Flags().StringVar(&excludeHashFile, "exclude-hashes", "", "File with base64 SHA1 hashes (one per line) of media files to skip")
+	rootCmd.Flags().BoolVar(&createAlbums, "create-albums", false, "Create the Google albums in Immich and add the matched assets (needs album write permissions)")
+	rootCmd.Flags().StringVar(&albumConflict, "album-conflict", mapper.AlbumConflictReuse, "What --create-albums does with an existing Immich album of the same name: reuse or skip")
+	rootCmd.Flags().StringVar(&inputFormat, "input-format", "", "Force how inputs are opened: zip, tgz or dir (default: detect by extension)")
+	rootCmd.Flags().BoolVar(&streamOutput, "stream", false, "Write mappings to the output as they are found instead of keeping them all in memory")
+	rootCmd.Flags().BoolVar(&failOnMultiple, "fail-on-multiple", false, "Report assets whose hash matches several Immich assets as ambiguous instead of using the first match")
+	rootCmd.Flags().StringVar(&matchTiers, "match-tiers", "", "Comma-separated matching tiers to try in order: hash, filename+timestamp, filename+size, transcoded-heic, taken-time, camera-fingerprint (default: hash, plus filename+timestamp with --fallback-filename)")
+	rootCmd.Flags().StringVar(&outputFormat, "format", "json", "Output format: json, ndjson for one JSON object per mapping and line, ids for a plain list of the matched Immich asset IDs, csv for google_url,immich_url rows, sed for a script that rewrites the links, table for reading in a terminal, md-report for a markdown report, or sqlite for a database file (needs --output)")
+	rootCmd.Flags().BoolVar(&verifyBrowser, "verify-against-browser", false, "Compare the media files found per folder with the counts in the takeout's archive_browser.html")
+	rootCmd.Flags().StringVar(&knownMapFile, "known-map", "", "CSV file of hash,immich_id rows with known matches, used without querying Immich")
+	rootCmd.Flags().BoolVar(&sniffContent, "sniff-content", false, "Check the content of files without extension to detect photos and videos")
+	rootCmd.Flags().StringVar(&splitOutput, "split-output", "", "Split the mappings into parts of at most this size (e.g. 100M) next to the -o file, which gets an index of the parts")
+	rootCmd.Flags().StringVar(&mediaType, "media-type", mapper.MediaTypeAll, "Only process photos or videos, by file extension: photo, video or all")
+	rootCmd.Flags().BoolVar(&compareExif, "compare-exif", false, "Compare orientation, camera and capture time in the EXIF data of matched JPEG/TIFF files with Immich")
+	rootCmd.Flags().BoolVar(&skipOrphans, "skip-orphans", false, "Skip the detection of media files without JSON sidecar (no hashing or lookup of orphans)")
+	rootCmd.Flags().StringArrayVar(&sharedLibraries, "shared-library", nil, "Search the library of another Immich user for assets of Google shared albums, as name=api-key (repeatable)")
+	rootCmd.Flags().StringVar(&cleanupFile, "cleanup-report", "", "Write a JSON file listing the Google albums whose assets were all matched (safe to delete) and the others")
+	rootCmd.Flags().StringVar(&preferVis, "prefer-visibility", mapper.VisibilityTimeline, "Visibility searched first, which wins if a hash matches in both: timeline or archive")
+	rootCmd.Flags().StringVar(&maxFileSize, "max-file-size", "", "Skip media files larger than this size (e.g. 2G) without hashing them, listing them under skipped_large")
+	rootCmd.Flags().BoolVar(&hashErrorsNF, "hash-errors-in-notfound", false, "List media files that couldn't be hashed in not_found (with reason hash-error)")
+	rootCmd.Flags().IntVar(&maxOpenArchives, "max-open-archives", 1, "Number of takeout archives open at the same time; more than 1 opens the next ones in the background")
+	rootCmd.Flags().BoolVar(&listOnly, "list-only", false, "Only list every Google Photos URL in the takeout with its JSON file, media file and title, without hashing or Immich")
+	rootCmd.Flags().BoolVar(&dryRunArchives, "dry-run-with-archives", false, "Like --dry-run, but list the resolved media files per archive in the output")
+	rootCmd.Flags().BoolVar(&includeStats, "include-stats", false, "Add the stats to the output without -v")
+	rootCmd.Flags().StringVar(&libraryRoot, "library-root", "", "Path of the takeout directory in an Immich external library; media files are looked up by path before hashing")
+	rootCmd.Flags().StringVar(&manifestFile, "upload-manifest", "", "Write a JSON file listing the orphan media files that aren't in Immich, grouped by archive and album, for uploading them")
+	rootCmd.Flags().StringVar(&fromDate, "from", "", "Only process assets taken on or after this date (YYYY-MM-DD or RFC 3339)")
+	rootCmd.Flags().StringVar(&toDate, "to", "", "Only process assets taken on or before this date (YYYY-MM-DD or RFC 3339)")
+	rootCmd.Flags().StringVar(&hashAlgo, "hash-algo", mapper.HashAlgoSHA1, "Algorithm of the hash in the output: sha1, md5 or xxhash (Immich is always searched by SHA1)")
+	rootCmd.Flags().StringVar(&hashBufferSize, "hash-buffer-size", "1M", "Size of the read buffer for hashing (e.g. 256K); larger reads can help on network shares and spinning disks")
+	rootCmd.Flags().DurationVar(&tsTolerance, "timestamp-tolerance", mapper.DefaultTimestampTolerance, "Tolerance of the Google timestamp when narrowing down multiple filename matches (e.g. 2s, 5m)")
+	rootCmd.Flags().BoolVar(&tsFallback, "timestamp-fallback", false, "Keep all filename matches if none is within --timestamp-tolerance, instead of matching nothing (the first one is used)")
+	rootCmd.Flags().DurationVar(&takenTimeWindow, "taken-time-window", mapper.DefaultTakenTimeWindow, "Tolerance of the taken-time match tier on each side of the Google timestamp (at most 10m)")
+	rootCmd.Flags().BoolVar(&outputBOM, "output-bom", false, "Start the output file with a UTF-8 byte order mark, for editors that otherwise guess a legacy encoding")
+	rootCmd.Flags().BoolVar(&validateTmpl, "validate-templates", false, "Render the --output-template with a sample result to stdout and exit, without processing a takeout")
+	rootCmd.Flags().IntVar(&concurrency, "concurrency", 0, "Number of media files hashed at the same time (default: number of CPUs)")
+	rootCmd.Flags().IntVar(&queryWorkers, "query-workers", 0, "Number of media files searched in Immich at the same time (default: --concurrency)")
+	rootCmd.Flags().StringVar(&cacheFile, "cache-file", "", "JSON file that keeps the hashes of media files between runs, so unchanged archives aren't hashed again")
+	rootCmd.Flags().StringVar(&checkpointFile, "checkpoint", "", "JSON file that records the progress, so an interrupted run continues where it stopped when started again with it")
+	rootCmd.Flags().BoolVar(&mergeSplits, "merge-splits", false, "Open the archive parts of a split takeout export (takeout-...-001.zip, -002.zip, ...) as one input, so sidecars and media files in different parts are matched")
+	rootCmd.Flags().BoolVar(&noProgress, "no-progress", false, "Don't print the progress percentage, which is shown if stderr is a terminal")
+	rootCmd.Flags().StringVar(&timezone, "timezone", "", "Timezone of the photos for timestamp matching (IANA name like Europe/Berlin or offset like +02:00; default: EXIF timezone, then local)")
 }
 
 func run(cmd *cobra.Command, args []string) error {
@@ -81,32 +236,374 @@ func run(cmd *cobra.Command, args []string) error {
 		cancel()
 	}()
 
+	if validateTmpl {
+		return validateTemplates()
+	}
+
 	// Validate flags
+	if listOnly {
+		if dryRunArchives || summaryOnly || streamOutput || groupBy != "" || outputTemplate != "" || splitOutput != "" || outputFormat != "json" {
+			return fmt.Errorf("--list-only can't be combined with --dry-run-with-archives, --summary-only, --stream, --group-by, --output-template, --split-output or --format")
+		}
+		dryRun = true
+	}
+	if dryRunArchives {
+		dryRun = true
+	}
 	if !dryRun {
+		if err := resolveConnection(); err != nil {
+			return err
+		}
 		if server == "" {
-			return fmt.Errorf("--server is required (unless using --dry-run)")
+			return fmt.Errorf("--server or IMMICH_SERVER is required (unless using --dry-run)")
 		}
 		if apiKey == "" {
-			return fmt.Errorf("--api-key is required (unless using --dry-run)")
+			return fmt.Errorf("--api-key, --api-key-file or IMMICH_API_KEY is required (unless using --dry-run)")
+		}
+	}
+
+	if createAlbums {
+		if dryRun {
+			return fmt.Errorf("--create-albums can't be used with --dry-run")
+		}
+		if albumConflict != mapper.AlbumConflictReuse && albumConflict != mapper.AlbumConflictSkip {
+			return fmt.Errorf("invalid --album-conflict %q (expected reuse or skip)", albumConflict)
+		}
+	}
+
+	if manifestFile != "" && (dryRun || skipOrphans) {
+		return fmt.Errorf("--upload-manifest can't be used with --dry-run or --skip-orphans, which don't check the orphans against Immich")
+	}
+
+	switch inputFormat {
+	case fshelper.FormatAuto, fshelper.FormatZip, fshelper.FormatTarGz, fshelper.FormatDir:
+	default:
+		return fmt.Errorf("invalid --input-format %q (expected zip, tgz or dir)", inputFormat)
+	}
+
+	switch mediaType {
+	case mapper.MediaTypeAll, mapper.MediaTypePhoto, mapper.MediaTypeVideo:
+	default:
+		return fmt.Errorf("invalid --media-type %q (expected photo, video or all)", mediaType)
+	}
+
+	switch hashAlgo {
+	case mapper.HashAlgoSHA1, mapper.HashAlgoMD5, mapper.HashAlgoXXHash:
+	default:
+		return fmt.Errorf("invalid --hash-algo %q (expected sha1, md5 or xxhash)", hashAlgo)
+	}
+
+	switch preferVis {
+	case mapper.VisibilityTimeline:
+	case mapper.VisibilityArchive:
+		if noArchiveSearch {
+			return fmt.Errorf("--prefer-visibility archive can't be used with --no-archive-search")
+		}
+	default:
+		return fmt.Errorf("invalid --prefer-visibility %q (expected timeline or archive)", preferVis)
+	}
+
+	if groupBy != "" && groupBy != "method" {
+		return fmt.Errorf("invalid --group-by %q (supported: method)", groupBy)
+	}
+
+	switch outputFormat {
+	case "json", "ndjson":
+		if outputFormat == "ndjson" && (summaryOnly || groupBy != "" || outputTemplate != "") {
+			return fmt.Errorf("--format ndjson can't be combined with --summary-only, --group-by or --output-template")
+		}
+	case "ids", "csv", "sed", "table", "md-report", "sqlite":
+		if summaryOnly || groupBy != "" || outputTemplate != "" || streamOutput {
+			return fmt.Errorf("--format %s can't be combined with --summary-only, --group-by, --output-template or --stream", outputFormat)
+		}
+		if outputFormat == "sqlite" && (outputFile == "" || outputFile == "-") {
+			return fmt.Errorf("--format sqlite needs --output")
+		}
+	default:
+		return fmt.Errorf("invalid --format %q (expected json, ndjson, ids, csv, sed, table, md-report or sqlite)", outputFormat)
+	}
+
+	if outputBOM {
+		if outputFile == "" || outputFile == "-" {
+			return fmt.Errorf("--output-bom needs --output")
+		}
+		if outputFormat == "sqlite" {
+			return fmt.Errorf("--output-bom can't be combined with --format sqlite")
+		}
+	}
+
+	if verifyURLs && (dryRun || streamOutput) {
+		return fmt.Errorf("--verify-urls can't be combined with --dry-run or --stream")
+	}
+
+	if checkpointFile != "" && (dryRun || streamOutput) {
+		return fmt.Errorf("--checkpoint can't be combined with --dry-run or --stream")
+	}
+
+	if streamOutput && (summaryOnly || groupBy != "" || outputTemplate != "") {
+		return fmt.Errorf("--stream can't be combined with --summary-only, --group-by or --output-template")
+	}
+
+	var splitBytes int64
+	if splitOutput != "" {
+		var err error
+		splitBytes, err = parseSize(splitOutput)
+		if err != nil {
+			return fmt.Errorf("invalid --split-output: %w", err)
+		}
+		if outputFile == "" {
+			return fmt.Errorf("--split-output needs --output")
+		}
+		if streamOutput || summaryOnly || groupBy != "" || outputTemplate != "" || outputFormat != "json" {
+			return fmt.Errorf("--split-output can't be combined with --stream, --summary-only, --group-by, --output-template or --format")
+		}
+	}
+
+	var maxFileBytes int64
+	if maxFileSize != "" {
+		var err error
+		maxFileBytes, err = parseSize(maxFileSize)
+		if err != nil {
+			return fmt.Errorf("invalid --max-file-size: %w", err)
+		}
+	}
+
+	if tsTolerance <= 0 {
+		return fmt.Errorf("invalid --timestamp-tolerance %s (expected more than 0)", tsTolerance)
+	}
+	if takenTimeWindow <= 0 || takenTimeWindow > mapper.MaxTakenTimeWindow {
+		return fmt.Errorf("invalid --taken-time-window %s (expected more than 0 and at most %s)", takenTimeWindow, mapper.MaxTakenTimeWindow)
+	}
+
+	if concurrency < 0 {
+		return fmt.Errorf("invalid --concurrency %d (expected at least 1, or 0 for the number of CPUs)", concurrency)
+	}
+	if queryWorkers < 0 {
+		return fmt.Errorf("invalid --query-workers %d (expected at least 1, or 0 for --concurrency)", queryWorkers)
+	}
+
+	hashBufferBytes, err := parseSize(hashBufferSize)
+	if err != nil || hashBufferBytes <= 0 || hashBufferBytes > 1<<30 {
+		return fmt.Errorf("invalid --hash-buffer-size %q (expected a size between 1 and 1G)", hashBufferSize)
+	}
+
+	// Parse the output template up front, so errors show before the run
+	var tmpl *template.Template
+	if outputTemplate != "" {
+		var err error
+		tmpl, err = mapper.ParseOutputTemplate(outputTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid --output-template: %w", err)
+		}
+	}
+
+	var excludeHashes map[string]bool
+	if excludeHashFile != "" {
+		var err error
+		excludeHashes, err = mapper.LoadHashList(excludeHashFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --exclude-hashes: %w", err)
 		}
 	}
 
+	var knownMap map[string]string
+	if knownMapFile != "" {
+		var err error
+		knownMap, err = mapper.LoadKnownMap(knownMapFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --known-map: %w", err)
+		}
+	}
+
+	var libraries []mapper.SharedLibrary
+	for _, s := range sharedLibraries {
+		lib, err := mapper.ParseSharedLibrary(s)
+		if err != nil {
+			return fmt.Errorf("invalid --shared-library: %w", err)
+		}
+		libraries = append(libraries, lib)
+	}
+
+	var loc *time.Location
+	if timezone != "" {
+		var err error
+		loc, err = parseTimezone(timezone)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Date-only bounds are whole days in the --timezone, or the local timezone
+	dateLoc := loc
+	if dateLoc == nil {
+		dateLoc = time.Local
+	}
+	var from, to time.Time
+	if fromDate != "" {
+		var err error
+		if from, err = parseDate(fromDate, dateLoc, false); err != nil {
+			return fmt.Errorf("invalid --from: %w", err)
+		}
+	}
+	if toDate != "" {
+		var err error
+		if to, err = parseDate(toDate, dateLoc, true); err != nil {
+			return fmt.Errorf("invalid --to: %w", err)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return fmt.Errorf("invalid --to %s: before --from %s", toDate, fromDate)
+	}
+
+	// Parse the matching tiers
+	tiers := mapper.DefaultMatchTiers(fallbackFilename)
+	if matchTiers != "" {
+		var err error
+		tiers, err = mapper.ParseMatchTiers(matchTiers)
+		if err != nil {
+			return fmt.Errorf("invalid --match-tiers: %w", err)
+		}
+		if fallbackFilename {
+			fmt.Fprintln(os.Stderr, "Warning: --fallback-filename is ignored with --match-tiers")
+		}
+	}
+
+	var xform *regexp.Regexp
+	var xformRepl string
+	if filenameXform != "" {
+		idx := strings.LastIndex(filenameXform, "=")
+		if idx < 0 {
+			return fmt.Errorf("invalid --filename-transform %q (expected REGEX=REPLACEMENT)", filenameXform)
+		}
+		var err error
+		xform, err = regexp.Compile(filenameXform[:idx])
+		if err != nil {
+			return fmt.Errorf("invalid --filename-transform regex: %w", err)
+		}
+		xformRepl = filenameXform[idx+1:]
+		if len(tiers) == 1 && tiers[0] == mapper.TierHash {
+			fmt.Fprintln(os.Stderr, "Warning: --filename-transform only applies with --fallback-filename or a filename tier")
+		}
+	}
+
+	variants, err := mapper.ParseFilenameVariants(filenameVariants)
+	if err != nil {
+		return fmt.Errorf("invalid --filename-variants: %w", err)
+	}
+	hasPrefixVariant := false
+	for _, v := range variants {
+		hasPrefixVariant = hasPrefixVariant || v == mapper.FilenameVariantPrefix
+	}
+	if hasPrefixVariant != (filenamePrefix != "") {
+		return fmt.Errorf("the prefix filename variant and --filename-prefix must be used together")
+	}
+
+	// With --stream, the output is opened up front and mappings are written as they are found
+	var stream *mapper.StreamWriter
+	var streamOut io.WriteCloser
+	var onMapping func(mapper.Mapping) error
+	if streamOutput {
+		var err error
+		streamOut, err = openOutput(ctx)
+		if err != nil {
+			return err
+		}
+		if outputFormat == "ndjson" {
+			stream = mapper.NewNDJSONWriter(streamOut, verbose)
+		} else {
+			stream = mapper.NewStreamWriter(streamOut, verbose, includeStats)
+		}
+		onMapping = stream.WriteMapping
+	}
+
+	httpOpts, err := httpOptions()
+	if err != nil {
+		return err
+	}
+	if err := checkSearchPaging(); err != nil {
+		return err
+	}
+
+	tracer, closeTrace, err := openTrace()
+	if err != nil {
+		return err
+	}
+	defer closeTrace()
+
 	// Create mapper
 	m, err := mapper.New(mapper.Config{
-		Server:           server,
-		APIKey:           apiKey,
-		SkipSSL:          skipSSL,
-		DryRun:           dryRun,
-		FallbackFilename: fallbackFilename,
-		TakeoutPaths:     args,
+		Server:               server,
+		APIKey:               apiKey,
+		SkipSSL:              skipSSL,
+		DryRun:               dryRun,
+		DryRunArchives:       dryRunArchives,
+		ListOnly:             listOnly,
+		FallbackFilename:     fallbackFilename,
+		DefaultHTTPS:         defaultHTTPS,
+		NormalizeURLs:        normalizeURLs,
+		HTTPConnections:      httpConnections,
+		HTTP:                 httpOpts,
+		Timezone:             loc,
+		LinkInAlbum:          linkInAlbum,
+		MapAlbums:            mapAlbums,
+		FilenameTransform:    xform,
+		FilenameReplacement:  xformRepl,
+		FilenameVariants:     variants,
+		FilenamePrefix:       filenamePrefix,
+		Deterministic:        deterministic,
+		NoArchiveSearch:      noArchiveSearch,
+		SearchTrash:          searchTrash,
+		SearchPageSize:       searchPageSize,
+		SearchMaxPages:       searchMaxPages,
+		PreferVisibility:     preferVis,
+		MaxFileSize:          maxFileBytes,
+		HashBufferSize:       int(hashBufferBytes),
+		HashAlgo:             hashAlgo,
+		From:                 from,
+		To:                   to,
+		LibraryRoot:          libraryRoot,
+		TakenTimeWindow:      takenTimeWindow,
+		TimestampTolerance:   tsTolerance,
+		TimestampFallback:    tsFallback,
+		IncludeMetadata:      includeMetadata,
+		VerifyURLs:           verifyURLs,
+		UploadManifest:       manifestFile != "",
+		HashErrorsInNotFound: hashErrorsNF,
+		MaxOpenArchives:      maxOpenArchives,
+		Concurrency:          concurrency,
+		QueryWorkers:         queryWorkers,
+		CacheFile:            cacheFile,
+		CheckpointFile:       checkpointFile,
+		MergeSplits:          mergeSplits,
+		VerifyChecksums:      verifyChecksums,
+		ExcludeHashes:        excludeHashes,
+		KnownMap:             knownMap,
+		SniffContent:         sniffContent,
+		MediaType:            mediaType,
+		CompareExif:          compareExif,
+		SkipOrphans:          skipOrphans,
+		SharedLibraries:      libraries,
+		InputFormat:          inputFormat,
+		FailOnMultiple:       failOnMultiple,
+		MatchTiers:           tiers,
+		VerifyAgainstBrowser: verifyBrowser,
+		OnMapping:            onMapping,
+		Progress:             progressReporter(),
+		TakeoutPaths:         args,
 		Logger: func(format string, args ...interface{}) {
 			fmt.Fprintf(os.Stderr, format+"\n", args...)
 		},
+		Trace: tracer,
 	})
 	if err != nil {
 		return err
 	}
-	defer m.Close()
+	// Also after an interrupt, which cancels Run: Close writes the checkpoint
+	defer func() {
+		if err := m.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}()
 
 	// Run mapping
 	fmt.Fprintln(os.Stderr, "Processing takeout files...")
@@ -115,20 +612,91 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Output results
-	var out *os.File
-	if outputFile != "" {
-		out, err = os.Create(outputFile)
+	// Output results (with --summary-only, only the stats go to the output file, if any)
+	if stream != nil {
+		if err := stream.Finish(result); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		if err := streamOut.Close(); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	} else if splitBytes > 0 {
+		open := func(name string) (io.WriteCloser, error) {
+			return openDestination(ctx, name)
+		}
+		if err := result.WriteSplitJSON(outputFile, splitBytes, verbose, includeStats, open); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	} else if !summaryOnly || outputFile != "" {
+		out, err := openOutput(ctx)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case listOnly:
+			err = result.WriteListJSON(out)
+		case summaryOnly:
+			err = result.WriteStatsJSON(out)
+		case tmpl != nil:
+			err = result.WriteTemplate(out, tmpl)
+		case outputFormat == "ndjson":
+			err = result.WriteNDJSON(out, verbose)
+		case outputFormat == "ids":
+			err = result.WriteIDs(out)
+		case outputFormat == "csv":
+			err = output.WriteCSV(out, result.Mappings)
+		case outputFormat == "sed":
+			err = output.WriteSed(out, result.Mappings)
+		case outputFormat == "table":
+			err = result.WriteTable(out, terminalWidth(out))
+		case outputFormat == "md-report":
+			err = result.WriteMarkdownReport(out)
+		case outputFormat == "sqlite":
+			err = result.WriteSQLite(out)
+		case groupBy == "method":
+			err = result.WriteGroupedJSON(out, verbose, includeStats)
+		default:
+			err = result.WriteJSON(out, verbose, includeStats)
+		}
+		if err == nil {
+			// Remote targets are uploaded on close
+			err = out.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+
+	if albumPlanFile != "" {
+		if err := writeAlbumPlan(result, albumPlanFile); err != nil {
+			return err
+		}
+	}
+
+	if cleanupFile != "" {
+		if err := writeCleanupReport(result, cleanupFile); err != nil {
+			return err
+		}
+	}
+
+	if manifestFile != "" {
+		if err := writeUploadManifest(result, manifestFile); err != nil {
+			return err
+		}
+	}
+
+	var albumReport *mapper.AlbumReport
+	if createAlbums {
+		albumReport, err = m.CreateAlbums(ctx, result.AlbumPlan, albumConflict)
 		if err != nil {
-			return fmt.Errorf("failed to create output file: %w", err)
+			return err
 		}
-		defer out.Close()
-	} else {
-		out = os.Stdout
 	}
 
-	if err := result.WriteJSON(out, verbose); err != nil {
-		return fmt.Errorf("failed to write output: %w", err)
+	// The run is complete, so the next one starts over
+	if err := m.RemoveCheckpoint(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	}
 
 	// Print summary to stderr
@@ -140,9 +708,79 @@ func run(cmd *cobra.Command, args []string) error {
 	fmt.Fprintf(os.Stderr, "  - by hash:                %d\n", result.Stats.MatchedByHash)
 	fmt.Fprintf(os.Stderr, "  - by filename:            %d\n", result.Stats.MatchedByFilename)
 	fmt.Fprintf(os.Stderr, "Not found in Immich:        %d\n", result.Stats.NotFoundInImmich)
+	if t := result.StatsByType[mapper.MediaTypePhoto]; t != nil {
+		fmt.Fprintf(os.Stderr, "Match rate photos:          %d/%d (%.1f%%)\n", t.Matched, t.Total, 100*t.MatchRate)
+	}
+	if t := result.StatsByType[mapper.MediaTypeVideo]; t != nil {
+		fmt.Fprintf(os.Stderr, "Match rate videos:          %d/%d (%.1f%%)\n", t.Matched, t.Total, 100*t.MatchRate)
+	}
 	fmt.Fprintf(os.Stderr, "No media file for JSON:     %d\n", result.Stats.NoMediaFile)
-	fmt.Fprintf(os.Stderr, "Orphan media (no JSON):     %d\n", result.Stats.OrphanMedia)
+	if result.Stats.EditedMedia > 0 {
+		fmt.Fprintf(os.Stderr, "Edited copies:              %d\n", result.Stats.EditedMedia)
+	}
+	if result.Stats.MotionPhotos > 0 {
+		fmt.Fprintf(os.Stderr, "Motion photos:              %d\n", result.Stats.MotionPhotos)
+	}
+	if !skipOrphans {
+		fmt.Fprintf(os.Stderr, "Orphan media (no JSON):     %d\n", result.Stats.OrphanMedia)
+	}
 	fmt.Fprintf(os.Stderr, "Hash computation errors:    %d\n", result.Stats.HashErrors)
+	if excludeHashFile != "" {
+		fmt.Fprintf(os.Stderr, "Skipped (excluded hash):    %d\n", result.Stats.SkippedExcluded)
+	}
+	if mediaType != mapper.MediaTypeAll {
+		fmt.Fprintf(os.Stderr, "Skipped (other media type): %d\n", result.Stats.SkippedMediaType)
+	}
+	if fromDate != "" || toDate != "" {
+		fmt.Fprintf(os.Stderr, "Skipped (date filter):      %d\n", result.Stats.SkippedByDateFilter)
+	}
+	if maxFileBytes > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped (too large):        %d\n", result.Stats.SkippedLarge)
+	}
+	if failOnMultiple {
+		fmt.Fprintf(os.Stderr, "Ambiguous (multiple hash):  %d\n", result.Stats.Ambiguous)
+	}
+	if verifyChecksums {
+		fmt.Fprintf(os.Stderr, "Checksum mismatches:        %d\n", result.Stats.ChecksumMismatch)
+	}
+	if compareExif {
+		fmt.Fprintf(os.Stderr, "EXIF mismatches:            %d\n", result.Stats.ExifMismatch)
+	}
+	if searchTrash {
+		fmt.Fprintf(os.Stderr, "Matched in Immich trash:    %d\n", result.Stats.InTrash)
+	}
+	if verifyBrowser {
+		fmt.Fprintf(os.Stderr, "Folder count mismatches:    %d\n", len(result.BrowserMismatches))
+	}
+	if verifyURLs {
+		fmt.Fprintf(os.Stderr, "Stale mappings (deleted):   %d\n", result.Stats.StaleMappings)
+	}
+	if len(result.SuspiciousMatches) > 0 {
+		fmt.Fprintf(os.Stderr, "Suspicious matches:         %d\n", len(result.SuspiciousMatches))
+	}
+
+	if dryRunArchives {
+		fmt.Fprintln(os.Stderr, "Archives:")
+		for _, a := range result.Archives {
+			fmt.Fprintf(os.Stderr, "  - %s: %d URLs, %d media files, %d without media file\n", a.Archive, a.GoogleURLs, len(a.Media), a.NoMediaFile)
+		}
+	}
+
+	if mapAlbums && !dryRun {
+		fmt.Fprintf(os.Stderr, "Albums mapped:              %d\n", len(result.Albums))
+		fmt.Fprintf(os.Stderr, "Albums not found in Immich: %d\n", len(result.NotFoundAlbums))
+	}
+
+	if cleanupFile != "" {
+		fmt.Fprintf(os.Stderr, "Albums safe to delete:      %d\n", len(result.Cleanup.SafeToDelete))
+		fmt.Fprintf(os.Stderr, "Albums to keep:             %d\n", len(result.Cleanup.Keep))
+	}
+
+	if albumReport != nil {
+		fmt.Fprintf(os.Stderr, "Albums created:             %d\n", len(albumReport.Created))
+		fmt.Fprintf(os.Stderr, "Albums updated:             %d\n", len(albumReport.Updated))
+		fmt.Fprintf(os.Stderr, "Albums skipped (existing):  %d\n", len(albumReport.Skipped))
+	}
 
 	if outputFile != "" {
 		fmt.Fprintf(os.Stderr, "\nOutput written to: %s\n", outputFile)
@@ -150,3 +788,277 @@ func run(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// validateTemplates parses the --output-template and executes it with
+// mapper.SampleResult, so template errors show without a run.
+func validateTemplates() error {
+	if outputTemplate == "" {
+		return fmt.Errorf("--validate-templates needs --output-template")
+	}
+	tmpl, err := mapper.ParseOutputTemplate(outputTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid --output-template: %w", err)
+	}
+	if err := mapper.SampleResult().WriteTemplate(os.Stdout, tmpl); err != nil {
+		return fmt.Errorf("invalid --output-template: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "\n%s is valid\n", outputTemplate)
+	return nil
+}
+
+// openOutput opens the output target (a file or remote URL), or returns
+// stdout if none is set.
+func openOutput(ctx context.Context) (io.WriteCloser, error) {
+	if outputFile == "" || outputFile == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	out, err := openDestination(ctx, outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output: %w", err)
+	}
+	return out, nil
+}
+
+// utf8BOM is the byte order mark written by --output-bom.
+const utf8BOM = "\xef\xbb\xbf"
+
+// openDestination opens an output file or remote URL and writes the BOM
+// with --output-bom.
+func openDestination(ctx context.Context, name string) (io.WriteCloser, error) {
+	out, err := destination.Open(ctx, name)
+	if err != nil || !outputBOM {
+		return out, err
+	}
+	if _, err := io.WriteString(out, utf8BOM); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+// progressInterval is the minimum time between two progress lines.
+const progressInterval = 500 * time.Millisecond
+
+// progressReporter returns the Progress callback of the mapper, which prints
+// the percentage done to stderr at most every progressInterval. It returns
+// nil with --no-progress or if stderr isn't a terminal.
+func progressReporter() func(done, total int) {
+	if noProgress || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	var last time.Time
+	return func(done, total int) {
+		if total == 0 || (done < total && time.Since(last) < progressInterval) {
+			return
+		}
+		last = time.Now()
+		fmt.Fprintf(os.Stderr, "Progress: %d%%\n", done*100/total)
+	}
+}
+
+// terminalWidth returns the width of the terminal the output goes to.
+// Other outputs use a fixed width of 120 columns.
+func terminalWidth(out io.Writer) int {
+	if nc, ok := out.(nopCloser); ok {
+		if f, ok := nc.Writer.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+			if width, _, err := term.GetSize(int(f.Fd())); err == nil {
+				return width
+			}
+		}
+	}
+	return 120
+}
+
+// nopCloser keeps stdout open when the output is closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// resolveConnection fills in --server and --api-key from the other sources
+// if they weren't given: the API key from --api-key-file or IMMICH_API_KEY,
+// the server from IMMICH_SERVER. Passing the key in a file or the
+// environment keeps it out of the shell history and the process list.
+func resolveConnection() error {
+	if server == "" {
+		server = os.Getenv("IMMICH_SERVER")
+	}
+	if apiKey != "" {
+		return nil
+	}
+	if apiKeyFile != "" {
+		data, err := os.ReadFile(apiKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --api-key-file: %w", err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		apiKey = strings.TrimSpace(line)
+		if apiKey == "" {
+			return fmt.Errorf("--api-key-file %s is empty", apiKeyFile)
+		}
+		return nil
+	}
+	apiKey = os.Getenv("IMMICH_API_KEY")
+	return nil
+}
+
+// checkSearchPaging validates --search-page-size and --search-max-pages.
+func checkSearchPaging() error {
+	if searchPageSize < 1 || searchPageSize > 1000 {
+		return fmt.Errorf("invalid --search-page-size %d (expected 1 to 1000)", searchPageSize)
+	}
+	if searchMaxPages < 1 {
+		return fmt.Errorf("invalid --search-max-pages %d (expected at least 1)", searchMaxPages)
+	}
+	return nil
+}
+
+// httpOptions returns the HTTP client tunables of --request-timeout,
+// --idle-timeout, --max-idle-conns and --max-conns-per-host, and the
+// retries of --max-retries and --retry-delay.
+func httpOptions() (mapper.HTTPOptions, error) {
+	if requestTimeout <= 0 || idleTimeout <= 0 {
+		return mapper.HTTPOptions{}, fmt.Errorf("--request-timeout and --idle-timeout must be positive")
+	}
+	if maxIdleConns < 0 || maxConnsPerHost < 0 {
+		return mapper.HTTPOptions{}, fmt.Errorf("--max-idle-conns and --max-conns-per-host can't be negative")
+	}
+	if maxRetries < 0 || retryDelay <= 0 {
+		return mapper.HTTPOptions{}, fmt.Errorf("--max-retries can't be negative and --retry-delay must be positive")
+	}
+	return mapper.HTTPOptions{
+		RequestTimeout:  requestTimeout,
+		IdleTimeout:     idleTimeout,
+		MaxIdleConns:    maxIdleConns,
+		MaxConnsPerHost: maxConnsPerHost,
+		MaxRetries:      maxRetries,
+		RetryDelay:      retryDelay,
+	}, nil
+}
+
+// openTrace returns the trace function for --trace and --trace-file, which
+// is nil if tracing is off, and a function to close the trace file.
+func openTrace() (func(format string, args ...interface{}), func() error, error) {
+	if !trace && traceFile == "" {
+		return nil, func() error { return nil }, nil
+	}
+
+	var w io.Writer = os.Stderr
+	closeTrace := func() error { return nil }
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create trace file: %w", err)
+		}
+		w, closeTrace = f, f.Close
+	}
+
+	// The connections are warmed up in parallel, so entries may be concurrent
+	var mu sync.Mutex
+	return func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, format+"\n", args...)
+	}, closeTrace, nil
+}
+
+// writeAlbumPlan writes the album plan of the result to a file.
+func writeAlbumPlan(result *mapper.Result, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create album plan file: %w", err)
+	}
+	defer f.Close()
+
+	if err := result.WriteAlbumPlanJSON(f); err != nil {
+		return fmt.Errorf("failed to write album plan: %w", err)
+	}
+	return nil
+}
+
+// writeCleanupReport writes the cleanup report of the result to a file.
+func writeCleanupReport(result *mapper.Result, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create cleanup report file: %w", err)
+	}
+	defer f.Close()
+
+	if err := result.WriteCleanupJSON(f); err != nil {
+		return fmt.Errorf("failed to write cleanup report: %w", err)
+	}
+	return nil
+}
+
+// writeUploadManifest writes the upload manifest of the result to a file.
+func writeUploadManifest(result *mapper.Result, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create upload manifest file: %w", err)
+	}
+	defer f.Close()
+
+	if err := result.WriteUploadManifestJSON(f); err != nil {
+		return fmt.Errorf("failed to write upload manifest: %w", err)
+	}
+	return nil
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix
+// (powers of 1024), e.g. "100M".
+func parseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	switch {
+	case strings.HasSuffix(num, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(num, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(num, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (expected bytes, optionally with K, M or G)", s)
+	}
+	return n * multiplier, nil
+}
+
+// parseDate parses a date as YYYY-MM-DD, in loc, or RFC 3339. With
+// endOfDay, a date without time is the last moment of the day.
+func parseDate(s string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date (expected YYYY-MM-DD or RFC 3339)", s)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// parseTimezone parses an IANA timezone name (e.g. "Europe/Berlin") or a
+// fixed UTC offset (e.g. "+02:00", "-0530").
+func parseTimezone(s string) (*time.Location, error) {
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(s, offset), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid --timezone offset %q (expected e.g. +02:00)", s)
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --timezone %q: %w", s, err)
+	}
+	return loc, nil
+}
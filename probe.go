@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/mapper"
+)
+
+var probeCmd = &cobra.Command{
+	Use:   "probe <file>",
+	Short: "Show what every matching tier finds in Immich for a single media file",
+	Long: `Hashes a single local media file and runs every matching tier for it,
+printing the Immich assets each tier finds with their IDs, URLs, filenames,
+visibility and albums. If a JSON sidecar in the same folder belongs to the
+file, its title and taken time are used like in a takeout. Useful to debug
+why a specific photo isn't mapped.
+
+Example:
+  google-photos-immich-urls probe -s https://immich.example.com -k YOUR_API_KEY "Photos from 2023/IMG_1234.jpg"`,
+	Args: cobra.ExactArgs(1),
+	RunE: runProbe,
+}
+
+func init() {
+	rootCmd.AddCommand(probeCmd)
+}
+
+func runProbe(cmd *cobra.Command, args []string) error {
+	if err := resolveConnection(); err != nil {
+		return err
+	}
+	if server == "" || apiKey == "" {
+		return fmt.Errorf("--server and --api-key (or --api-key-file, or IMMICH_SERVER and IMMICH_API_KEY) are required")
+	}
+
+	httpOpts, err := httpOptions()
+	if err != nil {
+		return err
+	}
+	if err := checkSearchPaging(); err != nil {
+		return err
+	}
+
+	tracer, closeTrace, err := openTrace()
+	if err != nil {
+		return err
+	}
+	defer closeTrace()
+
+	m, err := mapper.New(mapper.Config{
+		Server:          server,
+		APIKey:          apiKey,
+		SkipSSL:         skipSSL,
+		DefaultHTTPS:    defaultHTTPS,
+		NormalizeURLs:   normalizeURLs,
+		HTTPConnections: httpConnections,
+		HTTP:            httpOpts,
+		NoArchiveSearch: noArchiveSearch,
+		SearchTrash:     searchTrash,
+		SearchPageSize:  searchPageSize,
+		SearchMaxPages:  searchMaxPages,
+		Logger: func(format string, args ...interface{}) {
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		},
+		Trace: tracer,
+	})
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	result, err := m.Probe(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Hash:    %s\n", result.Hash)
+	if result.Sidecar != "" {
+		fmt.Printf("Sidecar: %s\n", result.Sidecar)
+	} else {
+		fmt.Println("Sidecar: none (the filename tiers search by the file name only)")
+	}
+	for _, tier := range result.Tiers {
+		fmt.Printf("\n%s: %d asset(s)\n", tier.Tier, len(tier.Assets))
+		for _, a := range tier.Assets {
+			fmt.Printf("  %s\t%s\t%s\t%s\t%s\n", a.ID, a.URL, a.Filename, a.Visibility, strings.Join(a.Albums, ", "))
+		}
+	}
+	return nil
+}
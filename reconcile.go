@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thedirtyfew/google-photos-immich-urls/internal/mapper"
+)
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile <mapping.json> <upload-report.json>",
+	Short: "Compare a mapping file with an immich-go upload report",
+	Long: `Cross-references the mappings of a previous run with the upload report of
+immich-go, to verify a migration is complete. It lists the assets that were
+uploaded but not mapped, and the mappings without an uploaded file. This
+command works offline and needs no Immich server.
+
+Example:
+  google-photos-immich-urls reconcile mapping.json immich-go-report.json`,
+	Args: cobra.ExactArgs(2),
+	RunE: runReconcile,
+}
+
+func init() {
+	reconcileCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path, file:// or s3://bucket/key URL (default: stdout)")
+	rootCmd.AddCommand(reconcileCmd)
+}
+
+func runReconcile(cmd *cobra.Command, args []string) error {
+	mappings, err := mapper.LoadMappings(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read mapping file: %w", err)
+	}
+	uploads, err := mapper.LoadUploadReport(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to read upload report: %w", err)
+	}
+
+	r := mapper.Reconcile(mappings, uploads)
+
+	out, err := openOutput(cmd.Context())
+	if err != nil {
+		return err
+	}
+	err = r.WriteJSON(out)
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Uploaded by immich-go:      %d\n", r.Stats.Uploaded)
+	fmt.Fprintf(os.Stderr, "Mapped:                     %d\n", r.Stats.Mapped)
+	fmt.Fprintf(os.Stderr, "Reconciled:                 %d\n", r.Stats.Reconciled)
+	fmt.Fprintf(os.Stderr, "Uploaded but not mapped:    %d\n", r.Stats.UploadedNotMapped)
+	fmt.Fprintf(os.Stderr, "Mapped but not uploaded:    %d\n", r.Stats.MappedNotUploaded)
+	return nil
+}